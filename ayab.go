@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Exportación para máquinas de tricotar: imagen de 1 bit para AYAB (un píxel
+// por aguja y por fila) y tarjeta perforada. La máquina teje punto jersey por
+// una sola fontura y solo distingue aguja seleccionada o no: una aguja
+// seleccionada (píxel negro, agujero en la tarjeta) es una aguja cuyo punto
+// pasa el carro de calado a la aguja de al lado antes de tejer la fila. Las
+// transferencias salen de los yo y las reducciones, como en laceTransfers.
+
+// Las tarjetas perforadas necesitan al menos 36 filas para dar la vuelta en la
+// máquina, y se solapan 2 filas al cerrarlas.
+const (
+	punchCardMinRows = 36
+	punchCardOverlap = 2
+)
+
+// machineProfile describe lo que sabe hacer un modelo de máquina.
+type machineProfile struct {
+	Name       string
+	Needles    int  // agujas de la fontura
+	Lace       bool // tiene carro de calado para transferir puntos
+	Electronic bool // la selección de agujas se puede mandar con AYAB
+	PunchCard  int  // ancho de la tarjeta perforada; 0 si no usa tarjetas
+}
+
+var machineProfiles = map[string]machineProfile{
+	"kh836": {Name: "Brother KH-836", Needles: 200, Lace: true, PunchCard: 24},
+	"kh868": {Name: "Brother KH-868", Needles: 200, Lace: true, PunchCard: 24},
+	"kh910": {Name: "Brother KH-910", Needles: 200, Lace: true, Electronic: true},
+	"kh930": {Name: "Brother KH-930", Needles: 200, Lace: true, Electronic: true},
+	"kh940": {Name: "Brother KH-940", Needles: 200, Lace: true, Electronic: true},
+	"kh965": {Name: "Brother KH-965", Needles: 200, Lace: true, Electronic: true},
+	"kh270": {Name: "Brother KH-270", Needles: 114, Electronic: true},
+}
+
+// machineProfileNamed busca un modelo de machineProfiles.
+func machineProfileNamed(name string) (machineProfile, error) {
+	if m, ok := machineProfiles[strings.ToLower(name)]; ok {
+		return m, nil
+	}
+	names := slices.Sorted(maps.Keys(machineProfiles))
+	return machineProfile{}, fmt.Errorf("unknown machine %q (known: %s)", name, strings.Join(names, ", "))
+}
+
+// machineRows devuelve las agujas seleccionadas de cada fila, en orden de
+// tejido, tal y como se ven por el derecho (la columna 0 es la de la
+// izquierda del gráfico). Los montajes no cuentan como fila.
+func machineRows(rows []*Row, m machineProfile) ([][]bool, error) {
+	laceRows, err := laceTransfers(rows)
+	if err != nil {
+		return nil, fmt.Errorf("%v on %s", err, m.Name)
+	}
+	var out [][]bool
+	for _, lr := range laceRows {
+		pr := lr.Row
+		for i, st := range pr.Row.Stitches {
+			var plain bool
+			switch s := st.(type) {
+			case *Knit:
+				plain = pr.RS
+			case *Purl:
+				plain = !pr.RS
+			case *Ktog:
+				plain = pr.RS && s.Count == 2
+			case *Ssk:
+				plain = pr.RS
+			case *Ptog:
+				plain = !pr.RS && s.Count == 2
+			case *Yo:
+				plain = true
+			}
+			// Por el revés el punto se ve al contrario: k en el revés es un
+			// punto del revés por el derecho, que una fontura no teje.
+			if !plain {
+				return nil, fmt.Errorf("row %d (%s), st %d: %s cannot be knitted on %s, it only knits stockinette",
+					pr.Index, side(pr.RS), i+1, st, m.Name)
+			}
+		}
+		if (len(lr.Transfers) > 0 || len(lr.Empty) > 0) && !m.Lace {
+			return nil, fmt.Errorf("row %d (%s): yo and decreases need a lace carriage, %s has none",
+				pr.Index, side(pr.RS), m.Name)
+		}
+		if lr.Passes > 1 {
+			return nil, fmt.Errorf("row %d (%s): sts move more than one needle, which takes %d lace carriage passes; see goknit lace",
+				pr.Index, side(pr.RS), lr.Passes)
+		}
+		if lr.Needles != pr.Row.weight() {
+			return nil, fmt.Errorf("row %d (%s): %d sts become %d; every yo needs a decrease next to it on the machine",
+				pr.Index, side(pr.RS), lr.Needles, pr.Row.weight())
+		}
+		if lr.Needles > m.Needles {
+			return nil, fmt.Errorf("row %d (%s) has %d sts but %s has %d needles",
+				pr.Index, side(pr.RS), lr.Needles, m.Name, m.Needles)
+		}
+
+		needles := make([]bool, lr.Needles)
+		for _, t := range lr.Transfers {
+			needles[t.From-1] = true
+		}
+		if len(out) > 0 && len(needles) != len(out[0]) {
+			return nil, fmt.Errorf("row %d has %d sts but row 1 has %d; the machine needs the same width on every row",
+				pr.Index, len(needles), len(out[0]))
+		}
+		out = append(out, needles)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("pattern has no rows to knit")
+	}
+	return out, nil
+}
+
+func side(rs bool) string {
+	if rs {
+		return "RS"
+	}
+	return "WS"
+}
+
+// writeAYAB escribe la imagen de 1 bit: la fila 1 abajo, como en el gráfico.
+func writeAYAB(rows []*Row, path string, m machineProfile) error {
+	if !m.Electronic {
+		return fmt.Errorf("%s is not an electronic machine; use a punch card", m.Name)
+	}
+	needles, err := machineRows(rows, m)
+	if err != nil {
+		return err
+	}
+	width, height := len(needles[0]), len(needles)
+	img := image.NewPaletted(image.Rect(0, 0, width, height), color.Palette{color.White, color.Black})
+	for r, row := range needles {
+		for x, selected := range row {
+			if selected {
+				img.SetColorIndex(x, height-1-r, 1)
+			}
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// punchCard dibuja la tarjeta de la máquina: el motivo se repite a lo ancho
+// hasta las columnas de la tarjeta y a lo largo hasta el mínimo de filas. "X"
+// es un agujero. La primera fila de la tarjeta es la fila 1 del patrón.
+func punchCard(rows []*Row, m machineProfile) (string, error) {
+	if m.PunchCard == 0 {
+		return "", fmt.Errorf("%s does not read punch cards", m.Name)
+	}
+	needles, err := machineRows(rows, m)
+	if err != nil {
+		return "", err
+	}
+	cardWidth := m.PunchCard
+	width := len(needles[0])
+	if cardWidth%width != 0 {
+		return "", fmt.Errorf("pattern is %d sts wide; a %d-stitch punch card needs a width that divides %d",
+			width, cardWidth, cardWidth)
+	}
+
+	repeats := 1
+	for repeats*len(needles) < punchCardMinRows {
+		repeats++
+	}
+	var card [][]bool
+	for range repeats {
+		card = append(card, needles...)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d-stitch punch card: %d sts x %d rows, repeated %dx%d (%d rows)\n",
+		cardWidth, width, len(needles), cardWidth/width, repeats, len(card))
+	line := func(label string, row []bool) {
+		fmt.Fprintf(&b, "%6s ", label)
+		for x := range cardWidth {
+			if row[x%width] {
+				b.WriteByte('X')
+			} else {
+				b.WriteByte('.')
+			}
+		}
+		b.WriteByte('\n')
+	}
+	for i, row := range card {
+		line(fmt.Sprint(i+1), row)
+	}
+	for i := range punchCardOverlap {
+		line("join", card[i%len(card)])
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Comprobación de equilibrio del calado: en una fila de calado cada yo suele
+// ir con su reducción dentro de la misma repetición. Una repetición que gana o
+// pierde puntos cambia la cuenta de la fila en cada vuelta, y eso es un error
+// salvo que la fila esté declarada como menguado o aumento con "shaping".
+
+// BalanceIssue es un problema de equilibrio de una fila. Level es "error" si
+// la cuenta no cuadra con lo declarado, "warning" si la fila cuadra pero sus
+// repeticiones no y "shaping" para los menguados y aumentos declarados.
+type BalanceIssue struct {
+	Row     PatternRow
+	Section string
+	Level   string
+	Message string
+}
+
+func (i BalanceIssue) String() string {
+	pos := ""
+	if line := i.Row.Row.Span.Start.Line(); line > 0 {
+		pos = fmt.Sprintf(" (line %d)", line)
+	}
+	return fmt.Sprintf("%s: %s row %d%s: %s", i.Level, i.Section, i.Row.Index, pos, i.Message)
+}
+
+// exprSource escribe una expresión parseada en sintaxis .knit.
+func exprSource(expr ParsedExpr) string {
+	switch e := expr.(type) {
+	case ParsedStitch:
+		st, err := NewCompiler().compileStitch(e)
+		if err != nil {
+			return e.String()
+		}
+		src, err := stitchSource(st)
+		if err != nil {
+			return e.String()
+		}
+		return src
+	case *PlaceMarker:
+		return "m" + e.Name
+	case *RemoveMarker:
+		return "rm" + e.Name
+	case *ParsedGroup:
+		parts := make([]string, len(e.Content))
+		for i, sub := range e.Content {
+			parts[i] = exprSource(sub)
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case *ParsedRepeatExact:
+		// k5 y p5 se escriben sin asterisco, k1 como k.
+		src := exprSource(e.Content)
+		if (src == "k" || src == "p") && e.Count > 0 {
+			if e.Count == 1 {
+				return src
+			}
+			return src + fmt.Sprint(e.Count)
+		}
+		return src + "*" + fmt.Sprint(e.Count)
+	case *ParsedRepeatNeg:
+		return exprSource(e.Content) + "*-" + fmt.Sprint(e.Count)
+	default:
+		return expr.String()
+	}
+}
+
+// unitDelta es lo que gana (positivo) o pierde una vuelta de expr. Las
+// repeticiones que rellenan la fila (*0, *-N) cuentan una sola vuelta.
+func unitDelta(expr ParsedExpr) int {
+	switch e := expr.(type) {
+	case ParsedStitch:
+		st, err := NewCompiler().compileStitch(e)
+		if err != nil {
+			return 0
+		}
+		return st.weight() - st.advance()
+	case *ParsedGroup:
+		d := 0
+		for _, sub := range e.Content {
+			d += unitDelta(sub)
+		}
+		return d
+	case *ParsedRepeatExact:
+		if e.Count == 0 {
+			return unitDelta(e.Content)
+		}
+		return e.Count * unitDelta(e.Content)
+	case *ParsedRepeatNeg:
+		return unitDelta(e.Content)
+	}
+	return 0
+}
+
+// unbalancedUnits describe las repeticiones de la fila que no están
+// equilibradas, de la más interna hacia fuera.
+func unbalancedUnits(exprs []ParsedExpr) []string {
+	var out []string
+	for _, expr := range exprs {
+		var content ParsedExpr
+		switch e := expr.(type) {
+		case *ParsedRepeatExact:
+			content = e.Content
+		case *ParsedRepeatNeg:
+			content = e.Content
+		case *ParsedGroup:
+			out = append(out, unbalancedUnits(e.Content)...)
+			continue
+		default:
+			continue
+		}
+		out = append(out, unbalancedUnits(unwrapGroup(content))...)
+		if d := unitDelta(content); d != 0 {
+			out = append(out, fmt.Sprintf("%s %s per repeat", exprSource(expr), gainsOrLoses(d)))
+		}
+	}
+	return out
+}
+
+func gainsOrLoses(d int) string {
+	switch {
+	case d == 1:
+		return "gains 1 st"
+	case d > 0:
+		return fmt.Sprintf("gains %d sts", d)
+	case d == -1:
+		return "loses 1 st"
+	default:
+		return fmt.Sprintf("loses %d sts", -d)
+	}
+}
+
+// checkBalance revisa el equilibrio de cada fila compilada y de las
+// repeticiones de la fila parseada de la que sale, que se encuentra por su
+// posición en el código fuente. Las filas de un bloque "repeat" se revisan
+// solo la primera vez.
+func checkBalance(pattern *CompiledPattern) []BalanceIssue {
+	var issues []BalanceIssue
+	for _, section := range pattern.Sections {
+		parsed := map[Span]*ParsedRow{}
+		for _, node := range section.Content {
+			switch n := node.(type) {
+			case *ParsedRow:
+				parsed[n.Span] = n
+			case *ParsedRepeatBlock:
+				for _, row := range n.Content {
+					parsed[row.Span] = row
+				}
+			}
+		}
+
+		seen := map[*ParsedRow]bool{}
+		for _, pr := range pattern.PatternRows(section.Name) {
+			source, ok := parsed[pr.Row.Span]
+			if pr.CastOn || !ok || seen[source] {
+				continue
+			}
+			seen[source] = true
+
+			delta := pr.Row.weight() - pr.Row.advance()
+			units := unbalancedUnits(source.Content)
+			issue := BalanceIssue{Row: pr, Section: section.Name, Level: "error"}
+			switch {
+			case delta != 0 && pr.Row.Shaping:
+				issue.Level = "shaping"
+				issue.Message = fmt.Sprintf("declared shaping, %s (%d -> %d sts)",
+					gainsOrLoses(delta), pr.Row.advance(), pr.Row.weight())
+			case delta != 0:
+				issue.Message = fmt.Sprintf("%s (%d -> %d sts) but is not declared as shaping",
+					gainsOrLoses(delta), pr.Row.advance(), pr.Row.weight())
+			case pr.Row.Shaping:
+				issue.Message = "declared as shaping but the stitch count does not change"
+			case len(units) > 0:
+				issue.Level = "warning"
+				issue.Message = "balanced overall, but repeats are not"
+			default:
+				continue
+			}
+			if len(units) > 0 {
+				issue.Message += ": " + strings.Join(units, "; ")
+			}
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Enlace entre una sesión y su patrón. La sesión guarda el hash del .knit, el
+// de las filas compiladas y uno corto por fila; al abrirla, si el patrón ha
+// cambiado, la fila guardada se busca en las filas nuevas comparando esos
+// hashes con diffSeq.
+
+// PatternBinding son los hashes del patrón con el que se tejía la sesión.
+type PatternBinding struct {
+	PatternHash string   `json:"patternHash,omitempty"`
+	RowsHash    string   `json:"rowsHash,omitempty"`
+	RowHashes   []string `json:"rowHashes,omitempty"`
+}
+
+func shortHash(data string, n int) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])[:n]
+}
+
+// bindingOf calcula los hashes del código src y de sus filas compiladas.
+func bindingOf(src []byte, rows []*Row) PatternBinding {
+	b := PatternBinding{PatternHash: shortHash(string(src), 64)}
+	for _, row := range rows {
+		b.RowHashes = append(b.RowHashes, shortHash(row.Section+"\x00"+row.String(), 12))
+	}
+	b.RowsHash = shortHash(strings.Join(b.RowHashes, ","), 64)
+	return b
+}
+
+// remapRow busca la fila row de las filas before en las filas after. Si la
+// fila sigue igual devuelve su nuevo índice y exact; si se cambió o se quitó,
+// la primera fila nueva que ocupa su lugar.
+func remapRow(before, after []string, row int) (int, bool) {
+	ops := diffSeq(len(before), len(after), func(i, j int) bool { return before[i] == after[j] })
+	for _, op := range ops {
+		if op.A == row && op.kind != '+' {
+			if op.kind == '=' {
+				return op.B, true
+			}
+			return min(op.B, max(len(after)-1, 0)), false
+		}
+	}
+	return max(len(after)-1, 0), false
+}
+
+// rebind compara la sesión con el patrón actual (src y sus filas), coloca la
+// fila y el punto en las filas nuevas y guarda los hashes nuevos en la
+// sesión. Devuelve un aviso para el usuario o "" si no hay nada que contar.
+func rebind(session *Session, src []byte, rows []*Row) string {
+	now := bindingOf(src, rows)
+	before := session.PatternBinding
+	session.PatternBinding = now
+
+	var warning string
+	switch {
+	case before.PatternHash == "":
+		// Sesión de antes de guardar los hashes: no hay con qué comparar.
+	case before.PatternHash == now.PatternHash:
+	case before.RowsHash == now.RowsHash:
+		warning = fmt.Sprintf("Pattern %s changed, but its rows are the same", session.Pattern)
+	case len(before.RowHashes) == 0:
+		warning = fmt.Sprintf("Pattern %s changed; check that row %d is still your row", session.Pattern, session.Row)
+	default:
+		row, exact := remapRow(before.RowHashes, now.RowHashes, session.Row)
+		switch {
+		case exact && row == session.Row:
+			warning = fmt.Sprintf("Pattern %s changed; row %d did not move", session.Pattern, row)
+		case exact:
+			warning = fmt.Sprintf("Pattern %s changed; row %d is now row %d", session.Pattern, session.Row, row)
+		default:
+			warning = fmt.Sprintf("Pattern %s changed and row %d was edited or removed; moved to row %d, check your place",
+				session.Pattern, session.Row, row)
+			session.Stitch = 0
+		}
+		session.Row = row
+	}
+
+	if len(rows) == 0 {
+		session.Row, session.Stitch = 0, 0
+	} else if session.Row >= len(rows) {
+		session.Row, session.Stitch = len(rows)-1, 0
+	}
+	if len(rows) > 0 && session.Stitch >= len(rows[session.Row].Stitches) {
+		session.Stitch = 0
+	}
+	return warning
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Folleto PDF para probadoras: cabecera con los metadatos, y por cada sección
+// el gráfico, las instrucciones fila a fila y, al final, la leyenda.
+
+const (
+	bookletMargin   = 50.0
+	bookletCellW    = 12.0 // puntos por casilla del gráfico
+	bookletCellH    = 10.0
+	bookletCellPx   = 4 // píxeles por punto al dibujar el gráfico
+	bookletBodySize = 10.0
+)
+
+// Descripción de cada punto para la leyenda.
+func stitchDescription(st Stitch) string {
+	switch s := st.(type) {
+	case *Knit:
+		return "Knit on RS, purl on WS"
+	case *Purl:
+		return "Purl on RS, knit on WS"
+	case *Ssk:
+		return "Slip, slip, knit the 2 slipped sts together"
+	case *Ktog:
+		return fmt.Sprintf("Knit %d sts together", s.Count)
+	case *Ptog:
+		return fmt.Sprintf("Purl %d sts together", s.Count)
+	case *Yo:
+		return "Yarn over"
+	case *CableRC:
+		return fmt.Sprintf("Slip %d sts to cable needle, hold in back, k%d, k%d from cable needle", s.BackCount, s.FrontCount, s.BackCount)
+	case *CableLC:
+		return fmt.Sprintf("Slip %d sts to cable needle, hold in front, k%d, k%d from cable needle", s.FrontCount, s.BackCount, s.FrontCount)
+	case *PurlCableRC:
+		return fmt.Sprintf("Slip %d sts to cable needle, hold in back, k%d, p%d from cable needle", s.BackCount, s.FrontCount, s.BackCount)
+	case *PurlCableLC:
+		return fmt.Sprintf("Slip %d sts to cable needle, hold in front, p%d, k%d from cable needle", s.FrontCount, s.BackCount, s.FrontCount)
+	case *Co:
+		return fmt.Sprintf("Cast on %d sts", s.Count)
+	case *Bo:
+		return fmt.Sprintf("Bind off %d sts", s.Count)
+	default:
+		return st.String()
+	}
+}
+
+type bookletWriter struct {
+	doc  *pdfDocument
+	page *pdfPage
+	y    float64
+}
+
+func (b *bookletWriter) newPage() {
+	b.page = b.doc.addPage()
+	b.y = pdfPageHeight - bookletMargin
+}
+
+// need empieza una página nueva si no quedan h puntos libres.
+func (b *bookletWriter) need(h float64) {
+	if b.page == nil || b.y-h < bookletMargin {
+		b.newPage()
+	}
+}
+
+func (b *bookletWriter) gap(h float64) {
+	b.y -= h
+}
+
+// text escribe un párrafo partiéndolo en líneas y en páginas si hace falta.
+func (b *bookletWriter) text(s string, size float64, bold bool) {
+	width := pdfPageWidth - 2*bookletMargin
+	for _, line := range pdfWrap(s, size, width, bold) {
+		b.need(size * 1.4)
+		b.y -= size * 1.4
+		b.page.text(bookletMargin, b.y, size, bold, line)
+	}
+}
+
+// chart dibuja el gráfico de las filas, partido en trozos que quepan en la página.
+func (b *bookletWriter) chart(rows []*Row) error {
+	grid, width := chartGrid(rows)
+	if width == 0 {
+		return nil
+	}
+	img, err := renderGrid(grid, width, bookletCellPx*int(bookletCellW), bookletCellPx*int(bookletCellH))
+	if err != nil {
+		return err
+	}
+
+	usableW, usableH := pdfPageWidth-2*bookletMargin, pdfPageHeight-2*bookletMargin
+	maxCols := int(usableW / bookletCellW)
+	maxRows := int(usableH / bookletCellH)
+	pxW, pxH := bookletCellPx*int(bookletCellW), bookletCellPx*int(bookletCellH)
+	for _, tile := range chartTiles(grid, width, pxW, pxH, maxCols, maxRows) {
+		w := float64(tile.Dx()/pxW) * bookletCellW
+		h := float64(tile.Dy()/pxH) * bookletCellH
+		b.need(h + bookletBodySize)
+		b.y -= h
+		b.page.image(b.doc, img.SubImage(tile), bookletMargin, b.y, w, h)
+		b.gap(bookletBodySize)
+	}
+	return nil
+}
+
+// legend lista los puntos usados en el patrón con su dibujo y su descripción.
+func (b *bookletWriter) legend(rows []*Row) error {
+	seen := map[string]Stitch{}
+	for _, row := range rows {
+		for _, st := range row.Stitches {
+			seen[st.String()] = st
+		}
+	}
+	var names []string
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		st := seen[name]
+		b.need(bookletCellH + 6)
+		b.y -= bookletCellH + 6
+		if getImagePath(st) != "ignore" {
+			tile, err := stitchTile(st)
+			if err != nil {
+				return err
+			}
+			b.page.image(b.doc, tile, bookletMargin, b.y, bookletCellW*float64(max(st.weight(), 1)), bookletCellH)
+		}
+		b.page.text(bookletMargin+60, b.y+2, bookletBodySize, true, name)
+		b.page.text(bookletMargin+120, b.y+2, bookletBodySize, false, stitchDescription(st))
+	}
+	return nil
+}
+
+// writeBooklet genera el folleto PDF del patrón en outputPath.
+func writeBooklet(pattern *CompiledPattern, outputPath string) error {
+	b := &bookletWriter{doc: newPDF()}
+
+	b.text(pattern.Title(), 20, true)
+	b.gap(6)
+	var keys []string
+	for key := range pattern.Meta {
+		if key != "title" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		b.text(fmt.Sprintf("%s: %s", key, pattern.Meta[key]), bookletBodySize, false)
+	}
+
+	for _, section := range pattern.Sections {
+		rows := pattern.SectionRows(section.Name)
+		b.gap(12)
+		b.text("Section: "+section.Name, 14, true)
+
+		b.gap(6)
+		b.text("Chart", 12, true)
+		b.gap(4)
+		if err := b.chart(rows); err != nil {
+			return fmt.Errorf("chart of section %q: %v", section.Name, err)
+		}
+
+		b.text("Written instructions", 12, true)
+		for _, row := range numberRows(rows) {
+			if row.CastOn {
+				b.text(fmt.Sprintf("Cast on %d sts.", row.Row.weight()), bookletBodySize, false)
+				continue
+			}
+			side := "WS"
+			if row.RS {
+				side = "RS"
+			}
+			b.text(fmt.Sprintf("Row %d (%s): %s (%d sts)", row.Index, side, row.Row.String(), row.Row.weight()),
+				bookletBodySize, false)
+		}
+	}
+
+	b.gap(12)
+	b.text("Legend", 14, true)
+	if err := b.legend(pattern.Compiler.Rows); err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return b.doc.write(out)
+}
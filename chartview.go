@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Vista de gráfico para la TUI: dibuja la rejilla de chartGrid con un
+// carácter por casilla, de abajo arriba como un gráfico impreso, con los
+// números de las filas del derecho a la derecha y los del revés a la
+// izquierda.
+
+// chartView es un tview.Primitive con el gráfico del patrón. Sigue la fila
+// actual mientras no se desplace a mano.
+type chartView struct {
+	*tview.Box
+	grid    [][]chartCell
+	width   int
+	current *Row
+	stitch  int
+	ascii   bool
+	scroll  int // filas del gráfico que quedan por debajo del borde
+	follow  bool
+}
+
+func newChartView() *chartView {
+	return &chartView{Box: tview.NewBox(), follow: true}
+}
+
+func (v *chartView) setRows(rows []*Row) {
+	v.grid, v.width = chartGrid(rows)
+}
+
+// setCurrent marca la fila y el punto por los que se va y vuelve a seguirlos.
+func (v *chartView) setCurrent(row *Row, stitch int) {
+	v.current, v.stitch = row, stitch
+	v.follow = true
+}
+
+// scrollBy sube (n positivo) o baja el gráfico n filas.
+func (v *chartView) scrollBy(n int) {
+	v.scroll = max(0, min(v.scroll+n, len(v.grid)-1))
+	v.follow = false
+}
+
+// unicodeSymbol es el símbolo de la casilla offset de un punto. El derecho va
+// en blanco, como en los gráficos impresos.
+func unicodeSymbol(cell chartCell, offset int) rune {
+	if cell.isNoStitch() {
+		return '▒'
+	}
+	switch cell.stitch.(type) {
+	case *Knit:
+		return ' '
+	case *Purl:
+		return '•'
+	case *Yo:
+		return '○'
+	case *Ktog:
+		return '╱'
+	case *Ssk:
+		return '╲'
+	case *Ptog:
+		return '◿'
+	case *CableRC, *PurlCableRC:
+		if offset == 0 {
+			return '╳'
+		}
+		return '»'
+	case *CableLC, *PurlCableLC:
+		if offset == 0 {
+			return '╳'
+		}
+		return '«'
+	}
+	return '?'
+}
+
+// cellStyle colorea las casillas por tipo de punto.
+func cellStyle(cell chartCell) tcell.Style {
+	style := tcell.StyleDefault.Foreground(tcell.ColorBlack)
+	if cell.isNoStitch() {
+		return style.Background(tcell.ColorGray).Foreground(tcell.ColorDarkGray)
+	}
+	switch cell.stitch.(type) {
+	case *Knit:
+		return style.Background(tcell.ColorWhite)
+	case *Purl:
+		return style.Background(tcell.ColorLightGray)
+	case *Yo:
+		return style.Background(tcell.ColorLightSkyBlue)
+	case *Ktog, *Ssk, *Ptog:
+		return style.Background(tcell.ColorLightCoral)
+	case *CableRC, *CableLC:
+		return style.Background(tcell.ColorLightGreen)
+	case *PurlCableRC, *PurlCableLC:
+		return style.Background(tcell.ColorDarkSeaGreen)
+	}
+	return style.Background(tcell.ColorWhite)
+}
+
+// currentIndex es la fila del gráfico de la fila actual, o -1 si no se dibuja
+// (las de montar no van en el gráfico).
+func (v *chartView) currentIndex() int {
+	for r, cells := range v.grid {
+		if len(cells) > 0 && cells[0].row == v.current {
+			return r
+		}
+	}
+	return -1
+}
+
+func (v *chartView) Draw(screen tcell.Screen) {
+	v.Box.DrawForSubclass(screen, v)
+	x, y, width, height := v.GetInnerRect()
+	if height <= 0 || len(v.grid) == 0 {
+		return
+	}
+	current := v.currentIndex()
+	if v.follow && current >= 0 {
+		if current < v.scroll {
+			v.scroll = current
+		} else if current >= v.scroll+height {
+			v.scroll = current - height + 1
+		}
+	}
+
+	const labelWidth = 5
+	for line := 0; line < height; line++ {
+		r := v.scroll + line
+		if r >= len(v.grid) {
+			break
+		}
+		sy := y + height - 1 - line
+		labelStyle := tcell.StyleDefault
+		if r == current {
+			labelStyle = labelStyle.Reverse(true)
+		}
+		// chartGrid invierte las filas pares: son las del derecho.
+		label := fmt.Sprintf("%*d ", labelWidth-1, r+1)
+		if r%2 == 0 {
+			label = fmt.Sprintf(" %-*d", labelWidth-1, r+1)
+			printStyled(screen, label, x+labelWidth+v.width, sy, x+width, labelStyle)
+		} else {
+			printStyled(screen, label, x, sy, x+width, labelStyle)
+		}
+
+		sx := x + labelWidth
+		for _, cell := range v.grid[r] {
+			style := cellStyle(cell)
+			if r == current {
+				if cell.index == v.stitch {
+					style = style.Background(tcell.ColorOrange)
+				} else {
+					style = style.Background(tcell.ColorYellow)
+				}
+			}
+			for offset := range cell.span {
+				symbol := unicodeSymbol(cell, offset)
+				if v.ascii {
+					symbol = asciiSymbol(cell, offset)
+				}
+				if sx < x+width {
+					screen.SetContent(sx, sy, symbol, nil, style)
+				}
+				sx++
+			}
+		}
+	}
+}
+
+// printStyled escribe text desde (x, y) sin pasar de la columna right.
+func printStyled(screen tcell.Screen, text string, x, y, right int, style tcell.Style) {
+	for _, r := range text {
+		if x >= right {
+			return
+		}
+		screen.SetContent(x, y, r, nil, style)
+		x++
+	}
+}
+
+// InputHandler pasa de página con PgUp y PgDn. El gráfico no coge el foco: la
+// tui le reenvía esas dos teclas, porque las flechas son de la lista de
+// acciones; fila a fila se mueve con la rueda del ratón.
+func (v *chartView) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	return v.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+		_, _, _, height := v.GetInnerRect()
+		switch event.Key() {
+		case tcell.KeyPgUp:
+			v.scrollBy(height)
+		case tcell.KeyPgDn:
+			v.scrollBy(-height)
+		}
+	})
+}
+
+func (v *chartView) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return v.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		if !v.InRect(event.Position()) {
+			return false, nil
+		}
+		switch action {
+		case tview.MouseScrollUp:
+			v.scrollBy(1)
+			return true, nil
+		case tview.MouseScrollDown:
+			v.scrollBy(-1)
+			return true, nil
+		}
+		return false, nil
+	})
+}
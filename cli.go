@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Subcomandos de línea de órdenes: goknit <comando> [opciones] archivo.knit
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+// Los comandos se registran en init porque sus funciones consultan el mapa
+// para mostrar el uso.
+func init() {
+	commands = map[string]command{
+		"balance":   {"balance pattern.knit", runBalance},
+		"ayab":      {"ayab [-machine kh930] pattern.knit out.png", runAYAB},
+		"chart":     {"chart [-cell WxH] [-dpi N] [-margin N] [-title T] [-paper A4] pattern.knit out.png", runChart},
+		"ir":        {"ir export pattern.knit | import pattern.json", runIR},
+		"diff":      {"diff [-chart out.png] old.knit new.knit", runDiff},
+		"estimate":  {"estimate [-gauge STSxROWS] [-yarn weight] [-blocking %] pattern.knit", runEstimate},
+		"import":    {"import [-csv] [-topdown] [-round] [-ws] [-section name] chart.txt", runImport},
+		"journal":   {"journal [-sessions dir] [-patterns dir] [-csv] session.json", runJournal},
+		"knitml":    {"knitml export pattern.knit | import pattern.xml | check pattern.knit...", runKnitml},
+		"lace":      {"lace pattern.knit", runLace},
+		"lint":      {"lint [-config goknit-lint.json] pattern.knit...", runLint},
+		"normalize": {"normalize pattern.knit", runNormalize},
+		"punchcard": {"punchcard [-machine kh868] pattern.knit", runPunchCard},
+		"pdf":       {"pdf pattern.knit out.pdf", runPdf},
+		"stats":     {"stats pattern.knit", runStats},
+		"tui":       {"tui [-sessions dir] [-patterns dir]", runTUI},
+		"written":   {"written [-lang en|es] pattern.knit", runWritten},
+	}
+}
+
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage())
+	}
+	return cmd.run(args[1:])
+}
+
+func usage() string {
+	var lines []string
+	for _, cmd := range commands {
+		lines = append(lines, "  goknit "+cmd.usage)
+	}
+	sort.Strings(lines)
+	return "usage:\n" + strings.Join(lines, "\n")
+}
+
+func runChart(args []string) error {
+	fs := flag.NewFlagSet("chart", flag.ContinueOnError)
+	opts := DefaultRenderOptions()
+	cell := fs.String("cell", "", "cell size in pixels, WIDTHxHEIGHT")
+	fs.IntVar(&opts.DPI, "dpi", opts.DPI, "resolution stored in the image")
+	fs.IntVar(&opts.Margin, "margin", opts.Margin, "margin in pixels")
+	fs.StringVar(&opts.Title, "title", "", "title drawn above the chart")
+	fs.StringVar(&opts.Paper, "paper", "", "split into printable pages (A4, A3, Letter)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: goknit %s", commands["chart"].usage)
+	}
+	if *cell != "" {
+		if _, err := fmt.Sscanf(*cell, "%dx%d", &opts.CellWidth, &opts.CellHeight); err != nil {
+			return fmt.Errorf("invalid cell size %q: %v", *cell, err)
+		}
+	}
+
+	pattern, err := loadPattern(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if opts.Title == "" {
+		opts.Title = pattern.Title()
+	}
+	opts.Format = formatForPath(fs.Arg(1))
+	return exportChart(pattern.Compiler.Rows, fs.Arg(1), opts)
+}
+
+func runPdf(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: goknit %s", commands["pdf"].usage)
+	}
+	pattern, err := loadPattern(args[0])
+	if err != nil {
+		return err
+	}
+	return writeBooklet(pattern, args[1])
+}
+
+func runWritten(args []string) error {
+	fs := flag.NewFlagSet("written", flag.ContinueOnError)
+	lang := fs.String("lang", string(English), "language of the instructions (en, es)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["written"].usage)
+	}
+	pattern, err := loadPattern(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	text, err := writtenInstructions(pattern, Language(*lang))
+	if err != nil {
+		return err
+	}
+	fmt.Print(text)
+	return nil
+}
+
+// runNormalize reescribe el patrón con las repeticiones detectadas y comprueba
+// que el resultado compila a los mismos puntos.
+func runNormalize(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["normalize"].usage)
+	}
+	pattern, err := loadPattern(args[0])
+	if err != nil {
+		return err
+	}
+	src, err := decompilePattern(pattern)
+	if err != nil {
+		return err
+	}
+	again, err := compilePattern(pattern.Name, strings.NewReader(src))
+	if err != nil {
+		return fmt.Errorf("normalized pattern does not compile: %v", err)
+	}
+	if err := sameStitches(pattern.Compiler.Rows, again.Compiler.Rows); err != nil {
+		return fmt.Errorf("normalized pattern knits differently: %v", err)
+	}
+	fmt.Print(src)
+	return nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	var opts ChartImportOptions
+	fs.BoolVar(&opts.CSV, "csv", false, "cells are comma separated")
+	fs.BoolVar(&opts.TopDown, "topdown", false, "the first line is row 1")
+	fs.BoolVar(&opts.Round, "round", false, "knitted in the round, every row is RS")
+	fs.BoolVar(&opts.FirstRowWS, "ws", false, "row 1 is a WS row")
+	fs.StringVar(&opts.Section, "section", "", "name of the section")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["import"].usage)
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if strings.HasSuffix(strings.ToLower(fs.Arg(0)), ".csv") {
+		opts.CSV = true
+	}
+
+	_, src, err := importChart(file, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Print(src)
+	return nil
+}
+
+// runKnitml exporta a KnitML, importa de KnitML (escribiendo el .knit) o
+// comprueba que los patrones dan los mismos puntos tras exportar e importar.
+func runKnitml(args []string) error {
+	if len(args) < 2 || (args[0] != "check" && len(args) != 2) {
+		return fmt.Errorf("usage: goknit %s", commands["knitml"].usage)
+	}
+	switch args[0] {
+	case "export":
+		pattern, err := loadPattern(args[1])
+		if err != nil {
+			return err
+		}
+		data, err := knitmlExport(pattern)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	case "import":
+		file, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		name := strings.TrimSuffix(filepath.Base(args[1]), filepath.Ext(args[1]))
+		pattern, err := knitmlImport(name, file)
+		if err != nil {
+			return err
+		}
+		src, err := decompilePattern(pattern)
+		if err != nil {
+			return err
+		}
+		fmt.Print(src)
+		return nil
+	case "check":
+		failed := 0
+		for _, path := range args[1:] {
+			pattern, err := loadPattern(path)
+			if err == nil {
+				err = knitmlRoundTrip(pattern)
+			}
+			if err != nil {
+				fmt.Printf("FAIL %s: %v\n", path, err)
+				failed++
+				continue
+			}
+			fmt.Printf("ok   %s\n", path)
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d of %d patterns failed the KnitML round trip", failed, len(args)-1)
+		}
+		return nil
+	}
+	return fmt.Errorf("usage: goknit %s", commands["knitml"].usage)
+}
+
+// runIR exporta el patrón compilado a JSON o importa el JSON y escribe el .knit
+// equivalente.
+func runIR(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: goknit %s", commands["ir"].usage)
+	}
+	switch args[0] {
+	case "export":
+		pattern, err := loadPattern(args[1])
+		if err != nil {
+			return err
+		}
+		return exportIR(pattern, os.Stdout)
+	case "import":
+		file, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		pattern, err := importIR(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", args[1], err)
+		}
+		src, err := decompilePattern(pattern)
+		if err != nil {
+			return err
+		}
+		fmt.Print(src)
+		return nil
+	}
+	return fmt.Errorf("usage: goknit %s", commands["ir"].usage)
+}
+
+func runAYAB(args []string) error {
+	fs := flag.NewFlagSet("ayab", flag.ContinueOnError)
+	machine := fs.String("machine", "kh930", "knitting machine model")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: goknit %s", commands["ayab"].usage)
+	}
+	m, err := machineProfileNamed(*machine)
+	if err != nil {
+		return err
+	}
+	pattern, err := loadPattern(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return writeAYAB(pattern.Compiler.Rows, fs.Arg(1), m)
+}
+
+func runPunchCard(args []string) error {
+	fs := flag.NewFlagSet("punchcard", flag.ContinueOnError)
+	machine := fs.String("machine", "kh868", "knitting machine model")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["punchcard"].usage)
+	}
+	m, err := machineProfileNamed(*machine)
+	if err != nil {
+		return err
+	}
+	pattern, err := loadPattern(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	card, err := punchCard(pattern.Compiler.Rows, m)
+	if err != nil {
+		return err
+	}
+	fmt.Print(card)
+	return nil
+}
+
+func runLace(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["lace"].usage)
+	}
+	pattern, err := loadPattern(args[0])
+	if err != nil {
+		return err
+	}
+	report, err := laceReport(pattern.Compiler.Rows)
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	return nil
+}
+
+func runEstimate(args []string) error {
+	fs := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	var opts EstimateOptions
+	gauge := fs.String("gauge", "", "blocked gauge in 10 cm, STSxROWS (default: meta gauge_sts/gauge_rows)")
+	fs.StringVar(&opts.Yarn, "yarn", "", "yarn weight (lace, fingering, sport, dk, worsted, aran, bulky, super-bulky)")
+	blocking := fs.Float64("blocking", 0, "growth when blocking, in % (default: meta blocking or 10)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// -blocking 0 es no bloquear, no "sin indicar".
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "blocking" {
+			opts.Blocking = blocking
+		}
+	})
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["estimate"].usage)
+	}
+	if *gauge != "" {
+		if _, err := fmt.Sscanf(*gauge, "%gx%g", &opts.Gauge.Sts, &opts.Gauge.Rows); err != nil {
+			return fmt.Errorf("invalid gauge %q: %v", *gauge, err)
+		}
+	}
+	pattern, err := loadPattern(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	est, err := estimatePattern(pattern, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Print(est)
+	return nil
+}
+
+func runStats(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["stats"].usage)
+	}
+	pattern, err := loadPattern(args[0])
+	if err != nil {
+		return err
+	}
+	for _, s := range patternStats(pattern) {
+		fmt.Print(s)
+	}
+	return nil
+}
+
+// runBalance avisa de las repeticiones de calado que ganan o pierden puntos y
+// falla si alguna fila cambia la cuenta sin estar declarada como shaping.
+func runBalance(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["balance"].usage)
+	}
+	pattern, err := loadPattern(args[0])
+	if err != nil {
+		return err
+	}
+	errors := 0
+	for _, issue := range checkBalance(pattern) {
+		fmt.Println(issue)
+		if issue.Level == "error" {
+			errors++
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d rows are not balanced", errors)
+	}
+	return nil
+}
+
+func runLint(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configPath := fs.String("config", "", "rules configuration (default: "+lintConfigName+" next to the pattern)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: goknit %s", commands["lint"].usage)
+	}
+	errors := 0
+	for _, path := range fs.Args() {
+		config, err := loadLintConfig(*configPath, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		issues, err := lintPattern(name, src, config)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		for _, issue := range issues {
+			fmt.Printf("%s:%s\n", path, issue)
+			if issue.Severity == "error" {
+				errors++
+			}
+		}
+	}
+	if errors > 0 {
+		return fmt.Errorf("%d errors", errors)
+	}
+	return nil
+}
+
+// runDiff compara dos versiones del patrón y dibuja el gráfico nuevo con las
+// casillas cambiadas marcadas, en texto o, con -chart, en imagen.
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	chart := fs.String("chart", "", "write the new chart with the changed cells outlined")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: goknit %s", commands["diff"].usage)
+	}
+	before, err := loadPattern(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	after, err := loadPattern(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	rowsBefore, rowsAfter := before.Compiler.Rows, after.Compiler.Rows
+	changes := diffRows(rowsBefore, rowsAfter)
+	fmt.Print(diffReport(rowsBefore, rowsAfter, changes))
+	if len(changes) == 0 {
+		return nil
+	}
+	marked := changedCells(changes)
+	if *chart != "" {
+		opts := DefaultRenderOptions()
+		opts.Format = formatForPath(*chart)
+		return exportDiffChart(rowsAfter, marked, *chart, opts)
+	}
+	fmt.Println()
+	fmt.Print(diffChart(rowsAfter, marked))
+	return nil
+}
+
+// storeFlags añade -sessions y -patterns a fs y devuelve la función que abre
+// el almacén con ellos, después de fs.Parse.
+func storeFlags(fs *flag.FlagSet) func() (*fileStore, error) {
+	sessions := fs.String("sessions", "", "session directory (default: $XDG_DATA_HOME/goknit/sessions)")
+	patterns := fs.String("patterns", "", "pattern directory (default: $XDG_DATA_HOME/goknit/patterns)")
+	return func() (*fileStore, error) {
+		store, err := newFileStore()
+		if err != nil {
+			return nil, err
+		}
+		if *sessions != "" {
+			store.SessionDir = *sessions
+		}
+		if *patterns != "" {
+			store.PatternDir = *patterns
+		}
+		return store, nil
+	}
+}
+
+func runTUI(args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ContinueOnError)
+	openStore := storeFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: goknit %s", commands["tui"].usage)
+	}
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	app(store)
+	return nil
+}
+
+// runJournal resume el diario de una sesión o lo escribe en CSV.
+func runJournal(args []string) error {
+	fs := flag.NewFlagSet("journal", flag.ContinueOnError)
+	openStore := storeFlags(fs)
+	asCSV := fs.Bool("csv", false, "write the journal entries as CSV")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: goknit %s", commands["journal"].usage)
+	}
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	file := fs.Arg(0)
+	session, err := store.LoadSession(file)
+	if err != nil {
+		return err
+	}
+	entries, err := store.ReadJournal(file)
+	if err != nil {
+		return err
+	}
+	if *asCSV {
+		return writeJournalCSV(os.Stdout, entries)
+	}
+
+	remaining := 0
+	if data, err := store.ReadPattern(session.Pattern); err == nil {
+		pattern, err := compilePattern(session.Pattern, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		remaining = len(pattern.Compiler.Rows) - 1 - session.Row
+	}
+	fmt.Print(journalStats(entries, remaining))
+	return nil
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -190,6 +190,46 @@ func (r *RepeatNeg) String() string {
 type Row struct {
 	Stitches []Stitch
 	Number   int
+	Section  string
+	Markers  []Marker
+	Span     Span // posición de la fila en el .knit
+	Shaping  bool // declarada en el .knit como fila que aumenta o mengua
+	Repeats  []RowRepeat
+	Block    *RowBlock // nil si la fila no sale de un bloque "repeat"
+}
+
+// RowBlock dice de qué bloque "repeat N { ... }" sale una fila: el bloque
+// (Id, desde 1 en orden de aparición), la vuelta del bloque (Iteration de
+// Times) y la fila dentro del bloque (Index de Size), todo desde 1.
+type RowBlock struct {
+	Id               int
+	Iteration, Times int
+	Index, Size      int
+}
+
+// RowRepeat son los puntos de una repetición de la fila, como (k2tog yo)*0:
+// empieza en Stitches[Start] y son Times vueltas de Size puntos.
+type RowRepeat struct {
+	Start, Size, Times int
+}
+
+// repeatAt devuelve la repetición que contiene el punto i y la vuelta (desde 1)
+// en la que cae.
+func (r *Row) repeatAt(i int) (RowRepeat, int, bool) {
+	for _, rep := range r.Repeats {
+		if rep.Size > 0 && i >= rep.Start && i < rep.Start+rep.Size*rep.Times {
+			return rep, (i-rep.Start)/rep.Size + 1, true
+		}
+	}
+	return RowRepeat{}, 0, false
+}
+
+// Marker es un marcador que se coloca (mA) o se retira (rmA) justo antes del
+// punto At de la fila; At == len(Stitches) es el final de la fila.
+type Marker struct {
+	Name   string
+	At     int
+	Remove bool
 }
 
 func (r *Row) weight() int {
@@ -222,6 +262,9 @@ type Compiler struct {
 	Errors     []error
 	Pos        CompilePosition
 	CurrentRow *Row
+	Section    string
+	block      *RowBlock // bloque que se está compilando
+	blocks     int
 }
 
 func NewCompiler() *Compiler {
@@ -239,6 +282,8 @@ func (c *Compiler) startNewRow() {
 	newRow := &Row{
 		Stitches: make([]Stitch, 0),
 		Number:   c.Pos.RowPos,
+		Section:  c.Section,
+		Block:    c.block,
 	}
 	c.CurrentRow = newRow
 	c.Pos.ColPos = 1
@@ -277,34 +322,26 @@ func (c *Compiler) expandGroup(compiledExpr Expr) ([]Stitch, error) {
 	return sts, nil
 }
 
-func (c *Compiler) expandRepeat(compiledExpr Expr) ([]Stitch, error) {
-	var sts []Stitch
+// repeatTimes calcula cuántas veces se teje una repetición. *0 rellena los
+// puntos que quedan de la fila anterior y *-N deja N sin tejer.
+func (c *Compiler) repeatTimes(compiledExpr Expr) (int, error) {
 	switch expr := compiledExpr.(type) {
 	case *RepeatExact:
-		times := expr.Count
-		if times == 0 {
-			if c.LastRow != nil {
-				remaining := c.LastRow.weight() - (c.Pos.ColPos - 1)
-				perRepeat := c.exprAdvance(expr.Content)
-				if perRepeat == 0 {
-					return nil, fmt.Errorf("repeat content has zero advance, cannot calculate repetitions")
-				}
-				times = remaining / perRepeat
-			} else {
-				return nil, fmt.Errorf("cannot infer repeat count: no previous row")
-			}
+		if expr.Count != 0 {
+			return expr.Count, nil
 		}
-		for range times {
-			expanded, err := c.expandExpr(expr.Content)
-			if err != nil {
-				return nil, err
-			}
-			sts = append(sts, expanded...)
-
+		if c.LastRow == nil {
+			return 0, fmt.Errorf("cannot infer repeat count: no previous row")
 		}
+		remaining := c.LastRow.weight() - (c.Pos.ColPos - 1)
+		perRepeat := c.exprAdvance(expr.Content)
+		if perRepeat == 0 {
+			return 0, fmt.Errorf("repeat content has zero advance, cannot calculate repetitions")
+		}
+		return remaining / perRepeat, nil
 	case *RepeatNeg:
 		if c.LastRow == nil {
-			return nil, fmt.Errorf("cannot expand RepeatNeg: no previous row to infer remaining stitches")
+			return 0, fmt.Errorf("cannot expand RepeatNeg: no previous row to infer remaining stitches")
 		}
 
 		total := c.LastRow.weight()
@@ -312,19 +349,34 @@ func (c *Compiler) expandRepeat(compiledExpr Expr) ([]Stitch, error) {
 		perRepeat := c.exprAdvance(expr.Content)
 
 		if perRepeat == 0 {
-			return nil, fmt.Errorf("repeat content has zero advance, cannot calculate repetitions")
+			return 0, fmt.Errorf("repeat content has zero advance, cannot calculate repetitions")
 		}
 
-		times := max((remaining-expr.Count)/perRepeat, 0)
-		for range times {
-			expanded, err := c.expandExpr(expr.Content)
-			if err != nil {
-				return nil, err
-			}
-			sts = append(sts, expanded...)
-		}
+		return max((remaining-expr.Count)/perRepeat, 0), nil
 	default:
-		return nil, fmt.Errorf("Expected repeat expression, received: %T", expr)
+		return 0, fmt.Errorf("Expected repeat expression, received: %T", expr)
+	}
+}
+
+func (c *Compiler) expandRepeat(compiledExpr Expr) ([]Stitch, error) {
+	var sts []Stitch
+	times, err := c.repeatTimes(compiledExpr)
+	if err != nil {
+		return nil, err
+	}
+	var content Expr
+	switch expr := compiledExpr.(type) {
+	case *RepeatExact:
+		content = expr.Content
+	case *RepeatNeg:
+		content = expr.Content
+	}
+	for range times {
+		expanded, err := c.expandExpr(content)
+		if err != nil {
+			return nil, err
+		}
+		sts = append(sts, expanded...)
 	}
 	return sts, nil
 }
@@ -354,16 +406,35 @@ func (c *Compiler) expandExpr(compiledExpr Expr) ([]Stitch, error) {
 
 func (c *Compiler) compileRow(parsedRow *ParsedRow) error {
 	c.startNewRow()
+	c.CurrentRow.Span = parsedRow.Span
+	c.CurrentRow.Shaping = parsedRow.Shaping
 	var sts []Stitch
 	for _, parsedExpr := range parsedRow.Content {
+		switch action := parsedExpr.(type) {
+		case *PlaceMarker:
+			c.CurrentRow.Markers = append(c.CurrentRow.Markers, Marker{Name: action.Name, At: len(sts)})
+			continue
+		case *RemoveMarker:
+			c.CurrentRow.Markers = append(c.CurrentRow.Markers, Marker{Name: action.Name, At: len(sts), Remove: true})
+			continue
+		}
 		e, err := c.compileExpr(parsedExpr)
 		if err != nil {
 			return err
 		}
+		times := 0
+		if _, ok := e.(Repeat); ok {
+			if times, err = c.repeatTimes(e); err != nil {
+				return err
+			}
+		}
 		expandedSts, err := c.expandExpr(e)
 		if err != nil {
 			return err
 		}
+		if times > 0 && len(expandedSts) > 0 {
+			c.CurrentRow.Repeats = append(c.CurrentRow.Repeats, RowRepeat{Start: len(sts), Size: len(expandedSts) / times, Times: times})
+		}
 		sts = append(sts, expandedSts...)
 		advance := 0
 		for _, st := range expandedSts {
@@ -451,10 +522,59 @@ func (c *Compiler) compileGroup(parsedGroup *ParsedGroup) (*Group, error) {
 	return &Group{Content: exprs}, nil
 }
 
+// CompileError es un error del patrón en Pos: el comienzo de la fila que no
+// compila o, si no se pudo leer el código, donde se paró el parser.
+type CompileError struct {
+	Pos Position
+	Err error
+}
+
+func (e *CompileError) Error() string { return e.Err.Error() }
+
+// compileRepeatBlock compila las filas del bloque Count veces. La primera fila
+// que no compila para el bloque y devuelve su error.
 func (c *Compiler) compileRepeatBlock(parsedRepeatBlock *ParsedRepeatBlock) error {
+	c.blocks++
+	defer func() { c.block = nil }()
 	for i := 0; i < parsedRepeatBlock.Count; i++ {
-		for _, row := range parsedRepeatBlock.Content {
-			c.compileRow(row)
+		for j, row := range parsedRepeatBlock.Content {
+			c.block = &RowBlock{
+				Id:        c.blocks,
+				Iteration: i + 1,
+				Times:     parsedRepeatBlock.Count,
+				Index:     j + 1,
+				Size:      len(parsedRepeatBlock.Content),
+			}
+			if err := c.compileRow(row); err != nil {
+				return &CompileError{
+					Pos: row.Span.Start,
+					Err: fmt.Errorf("compiling row %d of section %q: %v", c.Pos.RowPos, c.Section, err),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// compileSection compila las filas y bloques de repetición de una sección;
+// las filas compiladas quedan marcadas con el nombre de la sección.
+func (c *Compiler) compileSection(section *Section) error {
+	c.Section = section.Name
+	for _, node := range section.Content {
+		switch n := node.(type) {
+		case *ParsedRow:
+			if err := c.compileRow(n); err != nil {
+				return &CompileError{
+					Pos: n.Span.Start,
+					Err: fmt.Errorf("compiling row %d of section %q: %v", c.Pos.RowPos, section.Name, err),
+				}
+			}
+		case *ParsedRepeatBlock:
+			if err := c.compileRepeatBlock(n); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported node in section %q: %T", section.Name, node)
 		}
 	}
 	return nil
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Contadores de una sesión además del de filas: "menguar cada 6 filas",
+// "cruzar el cable cada 8". Un contador enlazado avanza solo al pasar de fila
+// dentro de su tramo de filas; uno sin enlazar se avanza a mano.
+
+// Counter cuenta pasos y salta cada Every. Total son los pasos dados; la
+// cuenta vuelve a 0 cada Every pasos. From y To son las filas (como
+// Session.Row) en las que cuenta un contador enlazado; To 0 es hasta el final.
+type Counter struct {
+	Name   string `json:"name"`
+	Every  int    `json:"every"`
+	Total  int    `json:"total"`
+	Linked bool   `json:"linked,omitempty"`
+	From   int    `json:"from,omitempty"`
+	To     int    `json:"to,omitempty"`
+}
+
+func (c Counter) validate() error {
+	switch {
+	case strings.TrimSpace(c.Name) == "":
+		return fmt.Errorf("counter without name")
+	case c.Every < 1:
+		return fmt.Errorf("counter %q: reset interval must be at least 1", c.Name)
+	case c.Total < 0:
+		return fmt.Errorf("counter %q: negative count", c.Name)
+	case c.From < 0 || c.To < 0 || (c.To > 0 && c.To < c.From):
+		return fmt.Errorf("counter %q: invalid rows %d-%d", c.Name, c.From, c.To)
+	}
+	return nil
+}
+
+// count es la cuenta desde el último salto.
+func (c Counter) count() int {
+	return c.Total % c.Every
+}
+
+// triggered dice si el contador acaba de saltar.
+func (c Counter) triggered() bool {
+	return c.Total > 0 && c.count() == 0
+}
+
+func (c Counter) covers(row int) bool {
+	return row >= c.From && (c.To == 0 || row <= c.To)
+}
+
+func (c Counter) String() string {
+	count := c.count()
+	if c.triggered() {
+		count = c.Every
+	}
+	s := fmt.Sprintf("%s %d/%d (x%d)", c.Name, count, c.Every, c.Total/c.Every)
+	if c.Linked {
+		if c.To > 0 {
+			s += fmt.Sprintf(" rows %d-%d", c.From, c.To)
+		} else {
+			s += fmt.Sprintf(" from row %d", c.From)
+		}
+	}
+	return s
+}
+
+// stepCounters avanza (delta 1) o retrocede (delta -1) los contadores. Con
+// linked solo se mueven los enlazados que cuentan la fila row; sin linked,
+// los manuales. Devuelve los que saltan.
+func stepCounters(counters []Counter, linked bool, row, delta int) []string {
+	var fired []string
+	for i := range counters {
+		c := &counters[i]
+		if c.Linked != linked || (linked && !c.covers(row)) {
+			continue
+		}
+		c.Total = max(c.Total+delta, 0)
+		if delta > 0 && c.triggered() {
+			fired = append(fired, c.Name)
+		}
+	}
+	return fired
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Descompilador: a partir de filas compiladas (o de un gráfico importado)
+// busca las repeticiones y escribe código .knit idiomático, con (k2tog yo)*4
+// dentro de la fila y *0 / *-N para la repetición principal.
+
+// stitchSource devuelve el código .knit de un punto compilado.
+func stitchSource(st Stitch) (string, error) {
+	cable := func(prefix string, front, back int, side string) string {
+		if front == back {
+			return fmt.Sprintf("%s%d%s", prefix, front, side)
+		}
+		return fmt.Sprintf("%s%d/%d%s", prefix, front, back, side)
+	}
+	switch s := st.(type) {
+	case *Knit:
+		return "k", nil
+	case *Purl:
+		return "p", nil
+	case *Ssk:
+		return "ssk", nil
+	case *Yo:
+		return "yo", nil
+	case *Ktog:
+		if s.Count < 2 || s.Count > 9 {
+			return "", fmt.Errorf("k%dtog cannot be written in .knit", s.Count)
+		}
+		return fmt.Sprintf("k%dtog", s.Count), nil
+	case *Ptog:
+		if s.Count < 2 || s.Count > 9 {
+			return "", fmt.Errorf("p%dtog cannot be written in .knit", s.Count)
+		}
+		return fmt.Sprintf("p%dtog", s.Count), nil
+	case *Co:
+		return fmt.Sprintf("co%d", s.Count), nil
+	case *Bo:
+		return fmt.Sprintf("bo%d", s.Count), nil
+	case *CableRC:
+		return cable("c", s.FrontCount, s.BackCount, "r"), nil
+	case *CableLC:
+		return cable("c", s.FrontCount, s.BackCount, "l"), nil
+	case *PurlCableRC:
+		return cable("p", s.FrontCount, s.BackCount, "r"), nil
+	case *PurlCableLC:
+		return cable("p", s.FrontCount, s.BackCount, "l"), nil
+	default:
+		return "", fmt.Errorf("unknown stitch %T", st)
+	}
+}
+
+// decompileRow escribe una fila. Con hasPrev la repetición principal usa *0 o
+// *-N, que el compilador resuelve con los puntos de la fila anterior; sin fila
+// anterior (la primera del patrón) todas las cuentas son exactas.
+func decompileRow(row *Row, hasPrev bool) (string, error) {
+	atoms := make([]string, len(row.Stitches))
+	for i, st := range row.Stitches {
+		src, err := stitchSource(st)
+		if err != nil {
+			return "", fmt.Errorf("row %d, st %d: %v", row.Number, i+1, err)
+		}
+		atoms[i] = src
+	}
+
+	// Solo se rellena con *0 o *-N si la repetición ocupa al menos media fila;
+	// si no, (k yo)*2 en mitad de la fila quedaría como (k yo)*-18.
+	start, size, times := bestRepeat(atoms, true)
+	if times < 2 || !hasPrev || 2*size*times < len(atoms) || markerInside(row.Markers, start, start+size*times) {
+		return strings.Join(compressMarked(atoms, 0, row.Markers), " "), nil
+	}
+
+	unit := row.Stitches[start : start+size]
+	suffix := row.Stitches[start+size*times:]
+	perRepeat, rest := 0, 0
+	for _, st := range unit {
+		perRepeat += st.advance()
+	}
+	for _, st := range suffix {
+		rest += st.advance()
+	}
+	// Una repetición que no consume puntos (solo yo) no se puede rellenar.
+	if perRepeat == 0 {
+		return strings.Join(compressMarked(atoms, 0, row.Markers), " "), nil
+	}
+
+	count := "0"
+	if len(suffix) > 0 {
+		count = fmt.Sprintf("-%d", rest)
+	}
+	end := start + size*times
+	var parts []string
+	parts = append(parts, compressMarked(atoms[:start], 0, row.Markers)...)
+	parts = append(parts, repeatSource(compressAtoms(atoms[start:start+size]), count))
+	parts = append(parts, compressMarked(atoms[end:], end, row.Markers)...)
+	return strings.Join(parts, " "), nil
+}
+
+// markerInside indica si algún marcador cae dentro de los puntos [start, end),
+// sin contar los bordes; ahí no puede ir dentro de la repetición.
+func markerInside(markers []Marker, start, end int) bool {
+	for _, m := range markers {
+		if m.At > start && m.At < end {
+			return true
+		}
+	}
+	return false
+}
+
+// compressMarked comprime atoms, que empiezan en el punto offset de la fila,
+// y escribe los marcadores que caen en ellos, incluidos los de los dos bordes.
+func compressMarked(atoms []string, offset int, markers []Marker) []string {
+	var parts []string
+	from := 0
+	for _, m := range markers {
+		at := m.At - offset
+		if at < 0 || at > len(atoms) {
+			continue
+		}
+		parts = append(parts, compressAtoms(atoms[from:at])...)
+		name := "m" + m.Name
+		if m.Remove {
+			name = "r" + name
+		}
+		parts = append(parts, name)
+		from = at
+	}
+	return append(parts, compressAtoms(atoms[from:])...)
+}
+
+// compressAtoms agrupa las repeticiones con cuentas exactas: k k k -> k*3.
+func compressAtoms(atoms []string) []string {
+	if len(atoms) < 2 {
+		return atoms
+	}
+	start, size, times := bestRepeat(atoms, false)
+	if times < 2 {
+		return atoms
+	}
+	var parts []string
+	parts = append(parts, compressAtoms(atoms[:start])...)
+	parts = append(parts, repeatSource(compressAtoms(atoms[start:start+size]), fmt.Sprint(times)))
+	parts = append(parts, compressAtoms(atoms[start+size*times:])...)
+	return parts
+}
+
+func repeatSource(unit []string, count string) string {
+	// k*5 se escribe k5; con *0 y *-N hace falta el asterisco.
+	if len(unit) == 1 && (unit[0] == "k" || unit[0] == "p") && !strings.HasPrefix(count, "-") && count != "0" {
+		return unit[0] + count
+	}
+	if len(unit) == 1 && !strings.ContainsAny(unit[0], " ()") {
+		return unit[0] + "*" + count
+	}
+	return "(" + strings.Join(unit, " ") + ")*" + count
+}
+
+// bestRepeat busca la unidad que, repetida seguida, ahorra más puntos. Ante un
+// empate se queda con la unidad más corta y la que empieza antes, salvo que
+// preferEnd pida la que llega al final de la fila: k5 (k2 k2tog yo k)*0 en vez
+// de k4 (k k2 k2tog yo)*-1 k.
+func bestRepeat(atoms []string, preferEnd bool) (start, size, times int) {
+	best := 0
+	n := len(atoms)
+	for s := 1; s <= n/2; s++ {
+		for a := 0; a+2*s <= n; a++ {
+			t := 1
+			for a+(t+1)*s <= n && slices.Equal(atoms[a+t*s:a+(t+1)*s], atoms[a:a+s]) {
+				t++
+			}
+			saved := (t - 1) * s
+			atEnd := preferEnd && a+t*s == n && start+size*times != n
+			if t >= 2 && (saved > best || saved == best && atEnd) {
+				best = saved
+				start, size, times = a, s, t
+			}
+		}
+	}
+	return start, size, times
+}
+
+// decompileRows escribe una sección con las filas dadas. Los bloques de filas
+// que se repiten seguidos se escriben como "repeat N { ... }".
+func decompileRows(name string, rows []*Row, hasPrev bool) (string, error) {
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		line, err := decompileRow(row, hasPrev || i > 0)
+		if err != nil {
+			return "", err
+		}
+		if row.Shaping {
+			line = "shaping " + line
+		}
+		lines[i] = line + ";"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "section %s {\n", name)
+	for i := 0; i < len(lines); {
+		size, times := bestRowBlock(lines[i:])
+		if times < 2 {
+			fmt.Fprintf(&b, "\t%s\n", lines[i])
+			i++
+			continue
+		}
+		fmt.Fprintf(&b, "\trepeat %d {\n", times)
+		for _, line := range lines[i : i+size] {
+			fmt.Fprintf(&b, "\t\t%s\n", line)
+		}
+		b.WriteString("\t}\n")
+		i += size * times
+	}
+	b.WriteString("}\n")
+	return b.String(), nil
+}
+
+// bestRowBlock busca al principio de lines el bloque de filas que más se
+// repite seguido. Solo compensa si ahorra más líneas que las del "repeat".
+func bestRowBlock(lines []string) (size, times int) {
+	best := 0
+	for s := 1; s <= len(lines)/2; s++ {
+		t := 1
+		for (t+1)*s <= len(lines) && slices.Equal(lines[t*s:(t+1)*s], lines[:s]) {
+			t++
+		}
+		if saved := (t-1)*s - 2; t >= 2 && saved > best {
+			best = saved
+			size, times = s, t
+		}
+	}
+	return size, times
+}
+
+// decompilePattern vuelve a escribir un patrón compilado como código .knit.
+func decompilePattern(pattern *CompiledPattern) (string, error) {
+	var b strings.Builder
+	if len(pattern.Meta) > 0 {
+		var keys []string
+		for key := range pattern.Meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b.WriteString("meta {\n")
+		for _, key := range keys {
+			fmt.Fprintf(&b, "\t%s \"%s\";\n", key, pattern.Meta[key])
+		}
+		b.WriteString("}\n")
+	}
+	hasPrev := false
+	for _, section := range pattern.Sections {
+		rows := pattern.SectionRows(section.Name)
+		src, err := decompileRows(section.Name, rows, hasPrev)
+		if err != nil {
+			return "", fmt.Errorf("section %q: %v", section.Name, err)
+		}
+		b.WriteString(src)
+		hasPrev = hasPrev || len(rows) > 0
+	}
+	return b.String(), nil
+}
+
+// sameStitches indica si dos listas de filas tejen los mismos puntos, ponen y
+// quitan los mismos marcadores en el mismo sitio y declaran las mismas filas
+// como shaping.
+func sameStitches(a, b []*Row) error {
+	if len(a) != len(b) {
+		return fmt.Errorf("different number of rows: %d and %d", len(a), len(b))
+	}
+	for i := range a {
+		if a[i].String() != b[i].String() {
+			return fmt.Errorf("row %d differs:\n  %s\n  %s", i+1, a[i], b[i])
+		}
+		if !slices.Equal(a[i].Markers, b[i].Markers) {
+			return fmt.Errorf("row %d has different markers:\n  %v\n  %v", i+1, a[i].Markers, b[i].Markers)
+		}
+		if a[i].Shaping != b[i].Shaping {
+			return fmt.Errorf("row %d: shaping %v and %v", i+1, a[i].Shaping, b[i].Shaping)
+		}
+	}
+	return nil
+}
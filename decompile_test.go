@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Un patrón con marcadores vuelve igual, marcadores incluidos, al pasar por
+// normalize y por ir export/import.
+func TestDecompileMarkers(t *testing.T) {
+	src := "section a {\nco12;\nk2 mA (k2tog yo)*4 mB k2;\np*0;\nk2 rmA (k2tog yo)*4 rmB k2;\nmC k12 mD;\n}\n"
+	pattern, err := compilePattern("markers", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	normalized, err := decompilePattern(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := compilePattern("markers", strings.NewReader(normalized))
+	if err != nil {
+		t.Fatalf("normalized pattern does not compile:\n%s\n%v", normalized, err)
+	}
+	if err := sameStitches(pattern.Compiler.Rows, again.Compiler.Rows); err != nil {
+		t.Errorf("normalize:\n%s\n%v", normalized, err)
+	}
+
+	var ir bytes.Buffer
+	if err := exportIR(pattern, &ir); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := importIR(&ir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err = decompilePattern(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err = compilePattern("markers", strings.NewReader(src)); err != nil {
+		t.Fatalf("imported pattern does not compile:\n%s\n%v", src, err)
+	}
+	if err := sameStitches(pattern.Compiler.Rows, again.Compiler.Rows); err != nil {
+		t.Errorf("ir import:\n%s\n%v", src, err)
+	}
+}
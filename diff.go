@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// Diferencias entre dos versiones de un patrón a nivel de filas compiladas:
+// qué filas se añaden o se quitan y, en las que cambian, qué puntos y cómo
+// cambia la cuenta. La vista de gráfico marca las casillas nuevas o cambiadas
+// de la versión nueva.
+
+// diffOp es un paso de la comparación: '=' si a[A] y b[B] son iguales, '-' si
+// a[A] se quita, '+' si b[B] se añade y '~' si b[B] sustituye a a[A].
+type diffOp struct {
+	kind byte
+	A, B int
+}
+
+// diffSeq compara dos secuencias de longitudes n y m con la subsecuencia
+// común más larga.
+func diffSeq(n, m int, equal func(i, j int) bool) []diffOp {
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if equal(i, j) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var ops []diffOp
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && equal(i, j):
+			ops = append(ops, diffOp{'=', i, j})
+			i++
+			j++
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', i, j})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', i, j})
+			j++
+		}
+	}
+	return ops
+}
+
+// RowChange es una fila que cambia entre las dos versiones. En las añadidas
+// Old es nil y en las quitadas New es nil.
+type RowChange struct {
+	Old, New *Row
+	Stitches []diffOp // solo si cambian puntos de una fila que está en las dos
+}
+
+// diffStitches compara los puntos de dos versiones de una fila posición a
+// posición, como los mira quien teje: un punto distinto en el mismo sitio es
+// un cambio, no un punto quitado y otro añadido. Lo que sobra al final de una
+// de las dos filas se quita o se añade.
+func diffStitches(a, b []Stitch) []diffOp {
+	var ops []diffOp
+	for i := range max(len(a), len(b)) {
+		switch {
+		case i >= len(b):
+			ops = append(ops, diffOp{'-', i, len(b)})
+		case i >= len(a):
+			ops = append(ops, diffOp{'+', len(a), i})
+		case a[i].String() == b[i].String():
+			ops = append(ops, diffOp{'=', i, i})
+		default:
+			ops = append(ops, diffOp{'~', i, i})
+		}
+	}
+	return ops
+}
+
+// diffRows compara las filas de dos patrones. Las filas quitadas seguidas de
+// filas añadidas se emparejan como filas cambiadas.
+func diffRows(before, after []*Row) []RowChange {
+	ops := diffSeq(len(before), len(after), func(i, j int) bool {
+		return before[i].Section == after[j].Section && before[i].String() == after[j].String()
+	})
+
+	var changes []RowChange
+	for k := 0; k < len(ops); {
+		if ops[k].kind == '=' {
+			k++
+			continue
+		}
+		var removed, added []int
+		for ; k < len(ops) && ops[k].kind != '='; k++ {
+			if ops[k].kind == '-' {
+				removed = append(removed, ops[k].A)
+			} else {
+				added = append(added, ops[k].B)
+			}
+		}
+		paired := min(len(removed), len(added))
+		for i := range paired {
+			a, b := before[removed[i]], after[added[i]]
+			changes = append(changes, RowChange{Old: a, New: b, Stitches: diffStitches(a.Stitches, b.Stitches)})
+		}
+		for _, i := range removed[paired:] {
+			changes = append(changes, RowChange{Old: before[i]})
+		}
+		for _, j := range added[paired:] {
+			changes = append(changes, RowChange{New: after[j]})
+		}
+	}
+	return changes
+}
+
+// rowLabel nombra una fila como en el patrón escrito: sección, número y lado.
+func rowLabel(labels map[*Row]string, row *Row) string {
+	if label, ok := labels[row]; ok {
+		return label
+	}
+	return fmt.Sprintf("%s row ?", row.Section)
+}
+
+func patternRowLabels(rows []*Row) map[*Row]string {
+	labels := map[*Row]string{}
+	bySection := map[string][]*Row{}
+	var order []string
+	for _, row := range rows {
+		if _, ok := bySection[row.Section]; !ok {
+			order = append(order, row.Section)
+		}
+		bySection[row.Section] = append(bySection[row.Section], row)
+	}
+	for _, section := range order {
+		for _, pr := range numberRows(bySection[section]) {
+			if pr.CastOn {
+				labels[pr.Row] = fmt.Sprintf("%s cast on", section)
+			} else {
+				labels[pr.Row] = fmt.Sprintf("%s row %d (%s)", section, pr.Index, side(pr.RS))
+			}
+		}
+	}
+	return labels
+}
+
+// diffReport escribe los cambios en texto.
+func diffReport(before, after []*Row, changes []RowChange) string {
+	oldLabels, newLabels := patternRowLabels(before), patternRowLabels(after)
+	var b strings.Builder
+	if len(changes) == 0 {
+		b.WriteString("no changes\n")
+		return b.String()
+	}
+	for _, c := range changes {
+		switch {
+		case c.Old == nil:
+			fmt.Fprintf(&b, "+ %s: %s (%d sts)\n", rowLabel(newLabels, c.New), rowSource(c.New), c.New.weight())
+		case c.New == nil:
+			fmt.Fprintf(&b, "- %s: %s (%d sts)\n", rowLabel(oldLabels, c.Old), rowSource(c.Old), c.Old.weight())
+		default:
+			fmt.Fprintf(&b, "~ %s -> %s", rowLabel(oldLabels, c.Old), rowLabel(newLabels, c.New))
+			if c.Old.weight() != c.New.weight() {
+				fmt.Fprintf(&b, ": %d -> %d sts (%+d)", c.Old.weight(), c.New.weight(), c.New.weight()-c.Old.weight())
+			}
+			b.WriteByte('\n')
+			for _, op := range c.Stitches {
+				switch op.kind {
+				case '-':
+					fmt.Fprintf(&b, "    - st %d %s\n", op.A+1, c.Old.Stitches[op.A])
+				case '+':
+					fmt.Fprintf(&b, "    + st %d %s\n", op.B+1, c.New.Stitches[op.B])
+				case '~':
+					fmt.Fprintf(&b, "    ~ st %d %s -> %s\n", op.A+1, c.Old.Stitches[op.A], c.New.Stitches[op.B])
+				}
+			}
+		}
+	}
+	return b.String()
+}
+
+// rowSource escribe la fila en .knit y, si no se puede, con sus puntos.
+func rowSource(row *Row) string {
+	if src, err := decompileRow(row, true); err == nil {
+		return src
+	}
+	return row.String()
+}
+
+// changedCells devuelve, para cada fila nueva, los puntos añadidos o cambiados.
+// Una fila añadida entera tiene todos sus puntos marcados.
+func changedCells(changes []RowChange) map[*Row]map[int]bool {
+	marked := map[*Row]map[int]bool{}
+	for _, c := range changes {
+		if c.New == nil {
+			continue
+		}
+		marked[c.New] = map[int]bool{}
+		for i := range c.New.Stitches {
+			if c.Old == nil {
+				marked[c.New][i] = true
+			}
+		}
+		for _, op := range c.Stitches {
+			if op.kind == '+' || op.kind == '~' {
+				marked[c.New][op.B] = true
+			}
+		}
+	}
+	return marked
+}
+
+// asciiSymbol es el carácter de la casilla offset de un punto en el gráfico
+// de texto. Los cables ocupan varias casillas: la primera lleva el símbolo y
+// las demás "=".
+func asciiSymbol(cell chartCell, offset int) rune {
+	if cell.isNoStitch() {
+		return 'x'
+	}
+	if offset > 0 {
+		return '='
+	}
+	switch cell.stitch.(type) {
+	case *Knit:
+		return '|'
+	case *Purl:
+		return '-'
+	case *Yo:
+		return 'o'
+	case *Ktog:
+		return '/'
+	case *Ssk:
+		return '\\'
+	case *Ptog:
+		return '%'
+	case *CableRC:
+		return 'R'
+	case *CableLC:
+		return 'L'
+	case *PurlCableRC:
+		return 'r'
+	case *PurlCableLC:
+		return 'l'
+	}
+	return '?'
+}
+
+// diffChart dibuja el gráfico nuevo en texto, de la última fila a la primera,
+// con las casillas cambiadas marcadas con "^" en una columna a la derecha.
+// Cada fila lleva su nombre del patrón escrito.
+func diffChart(rows []*Row, marked map[*Row]map[int]bool) string {
+	grid, width := chartGrid(rows)
+	labels := patternRowLabels(rows)
+	rowLabels := make([]string, len(grid))
+	labelW := 0
+	for r, cells := range grid {
+		if len(cells) > 0 {
+			rowLabels[r] = rowLabel(labels, cells[0].row)
+		}
+		labelW = max(labelW, len(rowLabels[r]))
+	}
+	var b strings.Builder
+	for r := len(grid) - 1; r >= 0; r-- {
+		var chart, mask strings.Builder
+		changed := false
+		for _, cell := range grid[r] {
+			hit := cell.index >= 0 && marked[cell.row][cell.index]
+			for offset := range cell.span {
+				chart.WriteRune(asciiSymbol(cell, offset))
+				if hit {
+					mask.WriteByte('^')
+					changed = true
+				} else {
+					mask.WriteByte(' ')
+				}
+			}
+		}
+		line := fmt.Sprintf("%-*s  %-*s", labelW, rowLabels[r], width, chart.String())
+		if changed {
+			line += "  " + mask.String()
+		}
+		b.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return b.String()
+}
+
+// Color del recuadro de las casillas cambiadas en el gráfico en imagen.
+var changedColor = color.RGBA{0xe0, 0x20, 0x20, 0xff}
+
+// exportDiffChart dibuja el gráfico nuevo como exportChart y recuadra en rojo
+// las casillas cambiadas.
+func exportDiffChart(rows []*Row, marked map[*Row]map[int]bool, path string, opts RenderOptions) error {
+	grid, width := chartGrid(rows)
+	if width == 0 {
+		return fmt.Errorf("no hay filas para dibujar")
+	}
+	cellW, cellH, err := opts.cellSize()
+	if err != nil {
+		return err
+	}
+	chart, err := renderGrid(grid, width, cellW, cellH)
+	if err != nil {
+		return err
+	}
+	border := max(1, min(cellW, cellH)/10)
+	for r, cells := range grid {
+		y := (len(grid) - 1 - r) * cellH
+		x := 0
+		for _, cell := range cells {
+			if cell.index >= 0 && marked[cell.row][cell.index] {
+				outline(chart, image.Rect(x, y, x+cell.span*cellW, y+cellH), border, changedColor)
+			}
+			x += cell.span * cellW
+		}
+	}
+	page := newPage(chart.Bounds().Dx()+2*opts.Margin, chart.Bounds().Dy()+2*opts.Margin)
+	draw.Draw(page, chart.Bounds().Add(image.Pt(opts.Margin, opts.Margin)), chart, image.Point{}, draw.Src)
+	return writeImage(path, page, opts)
+}
+
+func outline(dst *image.RGBA, r image.Rectangle, width int, c color.Color) {
+	fill := &image.Uniform{c}
+	draw.Draw(dst, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width), fill, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y), fill, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y), fill, image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y), fill, image.Point{}, draw.Src)
+}
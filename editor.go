@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Editor de archivos .knit para la TUI. Cada cambio vuelve a compilar el
+// texto; los errores van al log con línea y columna y a la derecha se ven las
+// filas compiladas. Ctrl-Z y Ctrl-Y deshacen y rehacen (lo hace el TextArea de
+// tview), Ctrl-S guarda y Esc cierra.
+
+// Diagnostic es un error del patrón en una posición del código. Pos vale
+// cero si no se sabe dónde está.
+type Diagnostic struct {
+	Pos     Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Pos.Line() == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", d.Pos.Line(), d.Pos.Column(), d.Message)
+}
+
+// diagnose compila src y devuelve el patrón, si compila, o el error que para
+// la compilación.
+func diagnose(name, src string) (*CompiledPattern, []Diagnostic) {
+	pattern, err := compilePattern(name, strings.NewReader(src))
+	if err == nil {
+		return pattern, nil
+	}
+	d := Diagnostic{Message: err.Error()}
+	var ce *CompileError
+	if errors.As(err, &ce) {
+		d.Pos = ce.Pos
+	}
+	return nil, []Diagnostic{d}
+}
+
+// patternEditor es el editor de un patrón del SessionStore.
+type patternEditor struct {
+	store   SessionStore
+	file    string
+	area    *tview.TextArea
+	preview *tview.TextView
+	layout  *tview.Flex
+
+	saved    string // texto guardado, para saber si hay cambios
+	lastDiag string // último diagnóstico escrito en el log
+	closing  bool   // se pulsó Esc con cambios sin guardar
+
+	log     func(string)
+	onSave  func(*CompiledPattern)
+	onClose func()
+}
+
+func newPatternEditor(store SessionStore, file string, log func(string), onSave func(*CompiledPattern), onClose func()) (*patternEditor, error) {
+	data, err := store.ReadPattern(file)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	e := &patternEditor{
+		store:   store,
+		file:    file,
+		area:    tview.NewTextArea(),
+		preview: tview.NewTextView().SetDynamicColors(true),
+		saved:   string(data),
+		log:     log,
+		onSave:  onSave,
+		onClose: onClose,
+	}
+	e.area.SetText(e.saved, false)
+	e.area.SetBorder(true)
+	e.preview.SetBorder(true).SetTitle("Rows")
+	e.layout = tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(e.area, 0, 3, true).
+		AddItem(e.preview, 0, 2, false)
+
+	e.area.SetChangedFunc(e.refresh)
+	e.area.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyCtrlS:
+			e.save()
+			return nil
+		case tcell.KeyEscape:
+			if e.modified() && !e.closing {
+				e.closing = true
+				e.log("Unsaved changes in " + e.file + "; press Esc again to discard them")
+				return nil
+			}
+			e.onClose()
+			return nil
+		}
+		e.closing = false
+		return event
+	})
+	e.refresh()
+	return e, nil
+}
+
+func (e *patternEditor) modified() bool {
+	return e.area.GetText() != e.saved
+}
+
+func (e *patternEditor) updateTitle() {
+	title := "Edit " + e.file
+	if e.modified() {
+		title += " *"
+	}
+	e.area.SetTitle(title + " (Ctrl-S save, Ctrl-Z undo, Ctrl-Y redo, Esc close)")
+}
+
+// refresh recompila el texto, escribe los errores en el log si han cambiado
+// y pinta las filas compiladas.
+func (e *patternEditor) refresh() {
+	e.updateTitle()
+	pattern, diags := diagnose(e.file, e.area.GetText())
+
+	var lines []string
+	for _, d := range diags {
+		sep := ":"
+		if d.Pos.Line() == 0 {
+			sep = ": "
+		}
+		lines = append(lines, e.file+sep+d.String())
+	}
+	if text := strings.Join(lines, "\n"); text != e.lastDiag {
+		e.lastDiag = text
+		if text == "" {
+			e.log(e.file + ": compiles")
+		} else {
+			e.log(text)
+		}
+	}
+
+	if pattern == nil {
+		e.preview.SetTitle("Rows (not compiling)")
+		return
+	}
+	rows := pattern.Compiler.Rows
+	labels := patternRowLabels(rows)
+	var b strings.Builder
+	for _, row := range rows {
+		fmt.Fprintf(&b, "[yellow]%s[-] (%d sts): %s\n", rowLabel(labels, row), row.weight(), row)
+	}
+	e.preview.SetTitle(fmt.Sprintf("Rows (%d)", len(rows)))
+	e.preview.SetText(b.String())
+}
+
+// save guarda el texto aunque no compile; solo avisa.
+func (e *patternEditor) save() {
+	text := e.area.GetText()
+	if err := e.store.WritePattern(e.file, []byte(text)); err != nil {
+		e.log("Error saving " + e.file + ": " + err.Error())
+		return
+	}
+	e.saved = text
+	e.closing = false
+	e.updateTitle()
+	pattern, diags := diagnose(e.file, text)
+	if len(diags) > 0 {
+		e.log(fmt.Sprintf("Saved %s with %d errors", e.file, len(diags)))
+	} else {
+		e.log("Saved " + e.file)
+	}
+	if pattern != nil {
+		e.onSave(pattern)
+	}
+}
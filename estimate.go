@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Estimación de medidas y de hilo a partir de la muestra. La muestra se lee de
+// la cabecera del patrón:
+//
+//	meta { gauge_sts 22; gauge_rows 30; yarn "dk"; blocking 10; }
+//
+// gauge_sts y gauge_rows son puntos y filas en 10 cm ya bloqueados; blocking
+// es lo que crece la pieza al bloquear, en tanto por ciento. Si falta la
+// muestra se usa la típica del grosor de hilo.
+
+// Gauge es la muestra en puntos y filas por 10 cm.
+type Gauge struct {
+	Sts, Rows float64
+}
+
+// yarnWeight describe un grosor de hilo estándar: su muestra típica y los
+// metros que trae un ovillo de 100 g.
+type yarnWeight struct {
+	gauge         Gauge
+	metersPer100g float64
+}
+
+var yarnWeights = map[string]yarnWeight{
+	"lace":        {Gauge{33, 40}, 800},
+	"fingering":   {Gauge{28, 36}, 400},
+	"sport":       {Gauge{24, 32}, 300},
+	"dk":          {Gauge{22, 28}, 230},
+	"worsted":     {Gauge{18, 24}, 200},
+	"aran":        {Gauge{16, 22}, 160},
+	"bulky":       {Gauge{13, 18}, 110},
+	"super-bulky": {Gauge{9, 12}, 70},
+}
+
+// Cada lazada gasta unos 3 anchos de punto de hilo; los puntos que no son un
+// derecho gastan más o menos según cuántas lazadas forman o cierran.
+const loopWidths = 3.0
+
+func stitchYarnFactor(st Stitch) float64 {
+	switch s := st.(type) {
+	case *Knit:
+		return 1
+	case *Purl:
+		return 1.05
+	case *Yo:
+		return 0.5
+	case *Ktog, *Ptog, *Ssk:
+		return 1.1
+	case *Co:
+		return 1.5 * float64(s.Count)
+	case *Bo:
+		return 1.5 * float64(s.Count)
+	default:
+		// Cables: un poco más por cruzar los puntos.
+		return 1.1 * float64(st.weight())
+	}
+}
+
+// EstimateOptions son los datos de la muestra y el hilo. Los campos a cero (y
+// Blocking a nil) se rellenan con la cabecera del patrón.
+type EstimateOptions struct {
+	Gauge    Gauge
+	Yarn     string
+	Blocking *float64 // crecimiento al bloquear, en %; 0 es no bloquear
+}
+
+// SectionEstimate son las medidas y el hilo de una sección.
+type SectionEstimate struct {
+	Name          string
+	Sts, Rows     int
+	Width, Length float64 // cm, bloqueado
+	Yarn          float64 // metros
+}
+
+type Estimate struct {
+	Options  EstimateOptions
+	Sections []SectionEstimate
+	Yarn     float64 // metros
+	Grams    float64 // 0 si no se conoce el hilo
+}
+
+// resolveEstimateOptions completa opts con la cabecera y el grosor del hilo.
+func resolveEstimateOptions(meta map[string]string, opts EstimateOptions) (EstimateOptions, error) {
+	number := func(key string, value *float64) error {
+		if *value != 0 || meta[key] == "" {
+			return nil
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(meta[key]), 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("meta %s: invalid number %q", key, meta[key])
+		}
+		*value = v
+		return nil
+	}
+	if err := number("gauge_sts", &opts.Gauge.Sts); err != nil {
+		return opts, err
+	}
+	if err := number("gauge_rows", &opts.Gauge.Rows); err != nil {
+		return opts, err
+	}
+	if opts.Blocking == nil {
+		blocking := 10.0
+		if meta["blocking"] != "" {
+			blocking = 0
+			if err := number("blocking", &blocking); err != nil {
+				return opts, err
+			}
+		}
+		opts.Blocking = &blocking
+	}
+	if opts.Yarn == "" {
+		opts.Yarn = strings.ToLower(strings.TrimSpace(meta["yarn"]))
+	}
+
+	if opts.Yarn != "" {
+		yarn, ok := yarnWeights[opts.Yarn]
+		if !ok {
+			return opts, fmt.Errorf("unknown yarn weight %q", opts.Yarn)
+		}
+		if opts.Gauge.Sts == 0 {
+			opts.Gauge.Sts = yarn.gauge.Sts
+		}
+		if opts.Gauge.Rows == 0 {
+			opts.Gauge.Rows = yarn.gauge.Rows
+		}
+	}
+	if opts.Gauge.Sts == 0 || opts.Gauge.Rows == 0 {
+		return opts, fmt.Errorf("no gauge: add gauge_sts and gauge_rows (or yarn) to the meta block")
+	}
+	return opts, nil
+}
+
+// estimatePattern calcula ancho, largo y metros de hilo de cada sección.
+func estimatePattern(pattern *CompiledPattern, opts EstimateOptions) (*Estimate, error) {
+	opts, err := resolveEstimateOptions(pattern.Meta, opts)
+	if err != nil {
+		return nil, err
+	}
+	stWidth := 10 / opts.Gauge.Sts
+	rowHeight := 10 / opts.Gauge.Rows
+
+	est := &Estimate{Options: opts}
+	for _, section := range pattern.Sections {
+		se := SectionEstimate{Name: section.Name}
+		yarn := 0.0
+		for _, pr := range pattern.PatternRows(section.Name) {
+			se.Sts = max(se.Sts, pr.Row.weight())
+			if !pr.CastOn {
+				se.Rows++
+			}
+			for _, st := range pr.Row.Stitches {
+				yarn += stitchYarnFactor(st)
+			}
+		}
+		se.Width = float64(se.Sts) * stWidth
+		se.Length = float64(se.Rows) * rowHeight
+		se.Yarn = yarn * loopWidths * stWidth / 100
+		est.Yarn += se.Yarn
+		est.Sections = append(est.Sections, se)
+	}
+	if yarn, ok := yarnWeights[opts.Yarn]; ok {
+		est.Grams = est.Yarn / yarn.metersPer100g * 100
+	}
+	return est, nil
+}
+
+// unblocked pasa una medida bloqueada a la de la pieza sin bloquear.
+func (e *Estimate) unblocked(cm float64) float64 {
+	return cm / (1 + *e.Options.Blocking/100)
+}
+
+func (e *Estimate) String() string {
+	var b strings.Builder
+	o := e.Options
+	fmt.Fprintf(&b, "Gauge: %g sts x %g rows in 10 cm (blocked)", o.Gauge.Sts, o.Gauge.Rows)
+	if o.Yarn != "" {
+		fmt.Fprintf(&b, ", %s yarn", o.Yarn)
+	}
+	fmt.Fprintf(&b, ", blocking +%g%%\n", *o.Blocking)
+	for _, s := range e.Sections {
+		fmt.Fprintf(&b, "%s: %d sts x %d rows\n", s.Name, s.Sts, s.Rows)
+		fmt.Fprintf(&b, "  blocked:   %.1f x %.1f cm\n", s.Width, s.Length)
+		fmt.Fprintf(&b, "  unblocked: %.1f x %.1f cm\n", e.unblocked(s.Width), e.unblocked(s.Length))
+		fmt.Fprintf(&b, "  yarn:      %.1f m\n", s.Yarn)
+	}
+	fmt.Fprintf(&b, "Total yarn: %.1f m", e.Yarn)
+	if e.Grams > 0 {
+		fmt.Fprintf(&b, " (about %.1f g)", e.Grams)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
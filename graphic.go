@@ -1,155 +1,546 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"image"
+	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"image/png"
+	"math"
 	"os"
-	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
 )
 
-func createImagesHorizontal(imagePaths []string) (*image.RGBA, error) {
-	if len(imagePaths) == 0 {
-		return nil, fmt.Errorf("no hay imágenes para procesar")
+// Color de las casillas "sin punto" del gráfico.
+var noStitchColor = color.RGBA{0xb4, 0xb4, 0xb4, 0xff}
+
+// chartCell es una casilla del gráfico. Un punto ocupa tantas casillas como
+// su weight(); las casillas "sin punto" tienen stitch nil y span 1. row e
+// index dicen de qué fila y de qué punto de row.Stitches sale la casilla
+// (index -1 en las "sin punto").
+type chartCell struct {
+	stitch Stitch
+	span   int
+	row    *Row
+	index  int
+}
+
+func (c chartCell) isNoStitch() bool {
+	return c.stitch == nil
+}
+
+// chartGrid coloca las filas compiladas en una rejilla de ancho fijo, en orden
+// de tejido (la primera fila es la de abajo del gráfico). Las filas del
+// derecho se leen de derecha a izquierda, así que se invierten.
+//
+// Cada punto vivo de la aguja tiene su columna. En una fila que mengua, los
+// puntos que sobran de cada disminución dejan su columna "sin punto" desde
+// esa fila hacia arriba; en una que aumenta, los aumentos (yo, co) abren
+// columnas nuevas, que son "sin punto" en las filas de debajo. Las filas que
+// no cambian la cuenta no abren ni cierran columnas aunque lleven yo y
+// disminuciones, como en los gráficos impresos.
+func chartGrid(rows []*Row) ([][]chartCell, int) {
+	var drawn []*Row
+	for _, row := range rows {
+		if !isCastOnRow(row) {
+			drawn = append(drawn, row)
+		}
+	}
+	if len(drawn) == 0 {
+		return nil, 0
+	}
+
+	// order son las columnas de izquierda a derecha; live, las de los puntos
+	// que quedan en la aguja, también de izquierda a derecha.
+	next := 0
+	newColumn := func() int {
+		next++
+		return next - 1
+	}
+	var order, live []int
+	for range drawn[0].advance() {
+		col := newColumn()
+		order = append(order, col)
+		live = append(live, col)
+	}
+	indexOf := func(col int) int {
+		for i, c := range order {
+			if c == col {
+				return i
+			}
+		}
+		return -1
 	}
 
-	var decodedImages []image.Image
-	for _, imgPath := range imagePaths {
-		imgFile, err := os.Open(imgPath)
-		if err != nil {
-			return nil, fmt.Errorf("error abriendo %s: %v", imgPath, err)
+	// owners[r][col] es la casilla de la fila r que ocupa la columna col.
+	owners := make([]map[int]chartCell, len(drawn))
+	rtl := true
+	for r, row := range drawn {
+		owners[r] = map[int]chartCell{}
+		seq := slices.Clone(live)
+		if rtl {
+			slices.Reverse(seq)
 		}
+		dead := max(0, row.advance()-row.weight())
+		added := max(0, row.weight()-row.advance())
 
-		img, _, err := image.Decode(imgFile)
-		imgFile.Close() // Cerrar inmediatamente después de usar
-		if err != nil {
-			return nil, fmt.Errorf("error decodificando %s: %v", imgPath, err)
+		p, last := 0, -1
+		var produced []int
+		take := func() int {
+			if p < len(seq) {
+				p++
+				return seq[p-1]
+			}
+			return -1
 		}
-		decodedImages = append(decodedImages, img)
+		// insert abre una columna justo después de la última tocada, en el
+		// sentido en que se teje la fila.
+		insert := func() int {
+			col := newColumn()
+			at := len(order)
+			ref, after := last, true
+			if ref < 0 && len(seq) > 0 {
+				ref, after = seq[0], false
+			}
+			if ref >= 0 {
+				at = indexOf(ref)
+				if after != rtl {
+					at++
+				}
+			}
+			order = slices.Insert(order, at, col)
+			return col
+		}
+
+		for i, st := range row.Stitches {
+			w, a := st.weight(), st.advance()
+			own, kill := w, 0
+			if d := a - w; d > 0 && dead > 0 {
+				kill = min(d, dead)
+				dead -= kill
+			}
+			open := 0
+			if d := w - a; d > 0 && added > 0 {
+				open = min(d, added)
+				added -= open
+				own -= open
+			}
+			var cols []int
+			for range own {
+				if col := take(); col >= 0 {
+					cols = append(cols, col)
+					last = col
+				}
+			}
+			for range open {
+				col := insert()
+				cols = append(cols, col)
+				last = col
+			}
+			for range kill {
+				if col := take(); col >= 0 {
+					last = col
+				}
+			}
+			if w > 0 {
+				for _, col := range cols {
+					owners[r][col] = chartCell{stitch: st, span: len(cols), row: row, index: i}
+				}
+			}
+			produced = append(produced, cols...)
+		}
+		// Puntos que la fila no llega a tejer: siguen en la aguja.
+		for col := take(); col >= 0; col = take() {
+			produced = append(produced, col)
+		}
+		if rtl {
+			slices.Reverse(produced)
+		}
+		live = produced
+		rtl = !rtl
 	}
 
-	// Calcular dimensiones del canvas final
-	totalWidth := 0
-	maxHeight := 0
-	for _, img := range decodedImages {
-		bounds := img.Bounds()
-		totalWidth += bounds.Dx()
-		if bounds.Dy() > maxHeight {
-			maxHeight = bounds.Dy()
+	grid := make([][]chartCell, len(drawn))
+	for r, row := range drawn {
+		var cells []chartCell
+		for i := 0; i < len(order); i++ {
+			cell, ok := owners[r][order[i]]
+			if !ok {
+				cells = append(cells, chartCell{span: 1, row: row, index: -1})
+				continue
+			}
+			// La casilla llega hasta la última de sus columnas; si entre
+			// medias hay columnas cerradas, las tapa.
+			end := i
+			for j := i; j < len(order); j++ {
+				if other, ok := owners[r][order[j]]; ok && other.index == cell.index {
+					end = j
+				}
+			}
+			cell.span = end - i + 1
+			cells = append(cells, cell)
+			i = end
 		}
+		grid[r] = cells
 	}
+	return grid, len(order)
+}
 
-	if totalWidth == 0 || maxHeight == 0 {
-		return nil, fmt.Errorf("dimensiones inválidas")
+// isCastOnRow indica si la fila solo monta puntos y no se dibuja en el gráfico.
+func isCastOnRow(row *Row) bool {
+	for _, st := range row.Stitches {
+		if _, ok := st.(*Co); !ok {
+			return false
+		}
 	}
+	return len(row.Stitches) > 0
+}
+
+// ImageFormat es el formato de salida de los gráficos.
+type ImageFormat int
 
-	// Crear canvas RGBA
-	rgba := image.NewRGBA(image.Rect(0, 0, totalWidth, maxHeight))
+const (
+	FormatPNG ImageFormat = iota
+	FormatJPEG
+)
+
+// RenderOptions configura la exportación del gráfico. Las medidas van en
+// píxeles; con CellWidth o CellHeight a 0 se usa el tamaño de las imágenes de
+// rec/. Las casillas de punto no son cuadradas, así que ancho y alto van por
+// separado. Con Paper vacío se genera una sola imagen; con un tamaño de papel
+// ("A4", "A3", "Letter") el gráfico se parte en hojas para imprimir.
+type RenderOptions struct {
+	Format     ImageFormat
+	CellWidth  int
+	CellHeight int
+	DPI        int
+	Margin     int
+	Title      string
+	Paper      string
+}
 
-	// Dibujar cada imagen en posición horizontal
-	currentX := 0
-	for _, img := range decodedImages {
-		bounds := img.Bounds()
-		drawRect := image.Rect(currentX, 0, currentX+bounds.Dx(), bounds.Dy())
-		draw.Draw(rgba, drawRect, img, image.Point{0, 0}, draw.Src)
-		currentX += bounds.Dx()
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		Format: FormatPNG,
+		DPI:    300,
+		Margin: 24,
 	}
+}
+
+// Tamaños de papel en milímetros.
+var paperSizes = map[string][2]float64{
+	"A4":     {210, 297},
+	"A3":     {297, 420},
+	"Letter": {215.9, 279.4},
+}
 
-	return rgba, nil
+// formatForPath deduce el formato a partir de la extensión del archivo.
+func formatForPath(path string) ImageFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return FormatJPEG
+	default:
+		return FormatPNG
+	}
 }
 
 func compileToImg(c Compiler, outputPath string) error {
-	var rowImages []*image.RGBA
+	opts := DefaultRenderOptions()
+	opts.Format = formatForPath(outputPath)
+	return exportChart(c.Rows, outputPath, opts)
+}
 
-	invert := true
-	for rowIndex, row := range c.Rows {
-		var imagePaths []string
-		for stitchIndex, stitch := range row.Stitches {
-			path := getImagePath(stitch)
-			switch path {
-			case "":
-				return fmt.Errorf("punto desconocido en fila %d, posición %d: %T\n", 
-					rowIndex, stitchIndex, stitch)
-			case "ignore": 
-					continue
-			default:
-				imagePaths = append(imagePaths, path)
-			}
+// exportChart dibuja las filas y las guarda en outputPath. Si el gráfico se
+// parte en varias hojas, cada una se guarda como nombre-N.ext.
+func exportChart(rows []*Row, outputPath string, opts RenderOptions) error {
+	pages, err := renderChart(rows, opts)
+	if err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(outputPath)
+	base := strings.TrimSuffix(outputPath, ext)
+	for i, page := range pages {
+		path := outputPath
+		if len(pages) > 1 {
+			path = fmt.Sprintf("%s-%d%s", base, i+1, ext)
 		}
-		
-		if len(imagePaths) == 0 {
-			continue
+		if err := writeImage(path, page, opts); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if invert {
-			imagePaths = reverse(imagePaths)
-		}
+// renderChart devuelve el gráfico con márgenes y título, en una sola imagen o
+// en una por hoja si opts.Paper está definido.
+func renderChart(rows []*Row, opts RenderOptions) ([]*image.RGBA, error) {
+	grid, width := chartGrid(rows)
+	if width == 0 {
+		return nil, fmt.Errorf("no hay filas para dibujar")
+	}
+
+	cellW, cellH, err := opts.cellSize()
+	if err != nil {
+		return nil, err
+	}
+	chart, err := renderGrid(grid, width, cellW, cellH)
+	if err != nil {
+		return nil, err
+	}
+
+	titleScale := max(1, opts.DPI/100)
+	titleH := 0
+	if opts.Title != "" {
+		titleH = textHeight(titleScale) + opts.Margin
+	}
+
+	if opts.Paper == "" {
+		bounds := chart.Bounds()
+		page := newPage(bounds.Dx()+2*opts.Margin, bounds.Dy()+2*opts.Margin+titleH)
+		drawText(page, opts.Margin, opts.Margin, opts.Title, titleScale, color.Black)
+		draw.Draw(page, bounds.Add(image.Pt(opts.Margin, opts.Margin+titleH)), chart, image.Point{}, draw.Src)
+		return []*image.RGBA{page}, nil
+	}
+
+	paper, ok := paperSizes[opts.Paper]
+	if !ok {
+		return nil, fmt.Errorf("tamaño de papel desconocido: %s", opts.Paper)
+	}
+	if opts.DPI <= 0 {
+		return nil, fmt.Errorf("hace falta un DPI para partir en hojas")
+	}
+	pageW := int(paper[0] / 25.4 * float64(opts.DPI))
+	pageH := int(paper[1] / 25.4 * float64(opts.DPI))
+	// En hojas siempre se pinta "(i/n)", aunque no haya título.
+	titleH = textHeight(titleScale) + opts.Margin
+	maxCols := (pageW - 2*opts.Margin) / cellW
+	maxRows := (pageH - 2*opts.Margin - titleH) / cellH
+	if maxCols <= 0 || maxRows <= 0 {
+		return nil, fmt.Errorf("las casillas no caben en una hoja %s", opts.Paper)
+	}
+
+	tiles := chartTiles(grid, width, cellW, cellH, maxCols, maxRows)
+	var pages []*image.RGBA
+	for i, tile := range tiles {
+		page := newPage(pageW, pageH)
+		title := fmt.Sprintf("%s (%d/%d)", opts.Title, i+1, len(tiles))
+		drawText(page, opts.Margin, opts.Margin, strings.TrimSpace(title), titleScale, color.Black)
+		dst := image.Rect(0, 0, tile.Dx(), tile.Dy()).Add(image.Pt(opts.Margin, opts.Margin+titleH))
+		draw.Draw(page, dst, chart, tile.Min, draw.Src)
+		pages = append(pages, page)
+	}
+	return pages, nil
+}
 
-		invert = !invert
-		rowImage, err := createImagesHorizontal(imagePaths)
+func (o RenderOptions) cellSize() (int, int, error) {
+	cellW, cellH := o.CellWidth, o.CellHeight
+	if cellW == 0 || cellH == 0 {
+		// El tamaño de casilla lo marca la imagen del punto derecho.
+		knit, err := loadTile(getImagePath(&Knit{}))
 		if err != nil {
-			return fmt.Errorf("error creando fila %d: %v", rowIndex, err)
+			return 0, 0, err
 		}
-		rowImages = append(rowImages, rowImage)
+		if cellW == 0 {
+			cellW = knit.Bounds().Dx()
+		}
+		if cellH == 0 {
+			cellH = knit.Bounds().Dy()
+		}
+	}
+	if cellW <= 0 || cellH <= 0 {
+		return 0, 0, fmt.Errorf("tamaño de casilla inválido %dx%d", cellW, cellH)
 	}
+	return cellW, cellH, nil
+}
 
-	invertRowImages := reverse(rowImages)
-	finalImage, err := stackImagesVertically(invertRowImages)
-	if err != nil {
-		return fmt.Errorf("error apilando imágenes: %v", err)
+func newPage(w, h int) *image.RGBA {
+	page := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(page, page.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	return page
+}
+
+// chartTiles parte el gráfico dibujado por renderGrid en trozos de como mucho
+// maxCols x maxRows casillas, empezando por las primeras filas (las de abajo).
+func chartTiles(grid [][]chartCell, width, cellW, cellH, maxCols, maxRows int) []image.Rectangle {
+	colBreaks := columnBreaks(grid, width, maxCols)
+	var tiles []image.Rectangle
+	for r0 := 0; r0 < len(grid); r0 += maxRows {
+		r1 := min(r0+maxRows, len(grid))
+		// La primera fila está abajo del gráfico.
+		y0 := (len(grid) - r1) * cellH
+		y1 := (len(grid) - r0) * cellH
+		for i := 0; i+1 < len(colBreaks); i++ {
+			tiles = append(tiles, image.Rect(colBreaks[i]*cellW, y0, colBreaks[i+1]*cellW, y1))
+		}
 	}
+	return tiles
+}
 
-	// Guardar la imagen final
-	out, err := os.Create(outputPath)
-	if err != nil {
-		return fmt.Errorf("error creando archivo de salida: %v", err)
+// columnBreaks reparte las columnas en hojas de como mucho maxCols columnas,
+// evitando cortar un cable por la mitad siempre que quepa en una hoja.
+func columnBreaks(grid [][]chartCell, width, maxCols int) []int {
+	breaks := []int{0}
+	for start := 0; start < width; {
+		end := min(start+maxCols, width)
+		for e := end; e > start && end < width; e-- {
+			if !cutsCell(grid, e) {
+				end = e
+				break
+			}
+		}
+		breaks = append(breaks, end)
+		start = end
 	}
-	defer out.Close()
+	return breaks
+}
 
-	err = jpeg.Encode(out, finalImage, &jpeg.Options{Quality: 90})
-	if err != nil {
-		return fmt.Errorf("error codificando JPEG: %v", err)
+// cutsCell indica si la columna col cae dentro de alguna casilla ancha.
+func cutsCell(grid [][]chartCell, col int) bool {
+	for _, cells := range grid {
+		x := 0
+		for _, cell := range cells {
+			if x < col && col < x+cell.span {
+				return true
+			}
+			x += cell.span
+		}
+	}
+	return false
+}
+
+// writeImage guarda img en el formato pedido, con la resolución en los
+// metadatos para que se imprima a su tamaño.
+func writeImage(path string, img image.Image, opts RenderOptions) error {
+	var buf bytes.Buffer
+	switch opts.Format {
+	case FormatJPEG:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+			return fmt.Errorf("error codificando JPEG: %v", err)
+		}
+	case FormatPNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return fmt.Errorf("error codificando PNG: %v", err)
+		}
+	default:
+		return fmt.Errorf("formato de imagen desconocido: %d", opts.Format)
 	}
 
+	data := buf.Bytes()
+	if opts.DPI > 0 {
+		if opts.Format == FormatPNG {
+			data = withPNGDensity(data, opts.DPI)
+		} else {
+			data = withJPEGDensity(data, opts.DPI)
+		}
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error creando archivo de salida: %v", err)
+	}
 	return nil
 }
 
-func stackImagesVertically(images []*image.RGBA) (*image.RGBA, error) {
-	if len(images) == 0 {
-		return nil, fmt.Errorf("no hay imágenes para apilar")
+// withPNGDensity añade un bloque pHYs justo después de IHDR.
+func withPNGDensity(data []byte, dpi int) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd {
+		return data
 	}
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], ppm)
+	binary.BigEndian.PutUint32(chunk[12:], ppm)
+	chunk[16] = 1 // metro
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
 
-	// Calcular dimensiones totales
-	maxWidth := 0
-	totalHeight := 0
-	for _, img := range images {
-		if img == nil {
-			return nil, fmt.Errorf("imagen nil encontrada")
-		}
-		bounds := img.Bounds()
-		if bounds.Dx() > maxWidth {
-			maxWidth = bounds.Dx()
-		}
-		totalHeight += bounds.Dy()
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
+
+// withJPEGDensity añade un segmento JFIF APP0 con la densidad tras el SOI.
+func withJPEGDensity(data []byte, dpi int) []byte {
+	if len(data) < 2 {
+		return data
+	}
+	d := uint16(min(dpi, math.MaxUint16))
+	app0 := []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 1,
+		byte(d >> 8), byte(d), byte(d >> 8), byte(d), 0, 0}
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app0...)
+	return append(out, data[2:]...)
+}
+
+// renderGrid dibuja la rejilla con la primera fila abajo, como un gráfico real.
+func renderGrid(grid [][]chartCell, width, cellW, cellH int) (*image.RGBA, error) {
+	if width == 0 || len(grid) == 0 || cellW <= 0 || cellH <= 0 {
+		return nil, fmt.Errorf("dimensiones inválidas")
 	}
 
-	if maxWidth == 0 || totalHeight == 0 {
-		return nil, fmt.Errorf("dimensiones inválidas para apilar")
+	canvas := image.NewRGBA(image.Rect(0, 0, width*cellW, len(grid)*cellH))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	for rowIndex, cells := range grid {
+		y := (len(grid) - 1 - rowIndex) * cellH
+		x := 0
+		for cellIndex, cell := range cells {
+			rect := image.Rect(x, y, x+cell.span*cellW, y+cellH)
+			if cell.isNoStitch() {
+				draw.Draw(canvas, rect, &image.Uniform{noStitchColor}, image.Point{}, draw.Src)
+			} else {
+				tile, err := stitchTile(cell.stitch)
+				if err != nil {
+					return nil, fmt.Errorf("fila %d, casilla %d: %v", rowIndex, cellIndex, err)
+				}
+				drawScaled(canvas, rect, tile)
+			}
+			x += cell.span * cellW
+		}
 	}
+	return canvas, nil
+}
 
-	// Crear canvas final
-	finalImage := image.NewRGBA(image.Rect(0, 0, maxWidth, totalHeight))
+var tileCache = map[string]image.Image{}
+
+func loadTile(path string) (image.Image, error) {
+	if img, ok := tileCache[path]; ok {
+		return img, nil
+	}
+	imgFile, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error abriendo %s: %v", path, err)
+	}
+	defer imgFile.Close()
 
-	// Dibujar imágenes verticalmente
-	currentY := 0
-	for _, img := range images {
-		bounds := img.Bounds()
-		drawRect := image.Rect(0, currentY, bounds.Dx(), currentY+bounds.Dy())
-		draw.Draw(finalImage, drawRect, img, image.Point{0, 0}, draw.Src)
-		currentY += bounds.Dy()
+	img, _, err := image.Decode(imgFile)
+	if err != nil {
+		return nil, fmt.Errorf("error decodificando %s: %v", path, err)
 	}
+	tileCache[path] = img
+	return img, nil
+}
 
-	return finalImage, nil
+// drawScaled copia src dentro de rect escalando por vecino más cercano, así un
+// cable de cuatro puntos ocupa cuatro casillas.
+func drawScaled(dst *image.RGBA, rect image.Rectangle, src image.Image) {
+	sb := src.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		sy := sb.Min.Y + (y-rect.Min.Y)*sb.Dy()/rect.Dy()
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			sx := sb.Min.X + (x-rect.Min.X)*sb.Dx()/rect.Dx()
+			dst.Set(x, y, src.At(sx, sy))
+		}
+	}
 }
 
 func getImagePath(stitch any) string {
@@ -175,6 +566,86 @@ func getImagePath(stitch any) string {
 	}
 }
 
+// stitchTile devuelve la imagen de la casilla de un punto. Los cables a la
+// izquierda son los de la derecha en espejo y los cables de revés llevan el
+// fondo gris; los puntos sin imagen (co en mitad de fila) se dibujan como una
+// casilla con su nombre.
+func stitchTile(st Stitch) (image.Image, error) {
+	key := fmt.Sprintf("%T", st)
+	if img, ok := tileCache[key]; ok {
+		return img, nil
+	}
+	var img image.Image
+	var err error
+	switch st.(type) {
+	case *CableLC:
+		img, err = derivedTile(&CableRC{}, true, false)
+	case *PurlCableRC:
+		img, err = derivedTile(&CableRC{}, false, true)
+	case *PurlCableLC:
+		img, err = derivedTile(&CableRC{}, true, true)
+	default:
+		if path := getImagePath(st); path != "" && path != "ignore" {
+			return loadTile(path)
+		}
+		key = "label " + st.String()
+		if img, ok := tileCache[key]; ok {
+			return img, nil
+		}
+		img, err = labelTile(st)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tileCache[key] = img
+	return img, nil
+}
+
+// derivedTile copia la imagen de st, en espejo con mirror y con el fondo
+// blanco pasado a gris con purl.
+func derivedTile(st Stitch, mirror, purl bool) (image.Image, error) {
+	src, err := loadTile(getImagePath(st))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			sx := b.Min.X + x
+			if mirror {
+				sx = b.Max.X - 1 - x
+			}
+			c := color.RGBAModel.Convert(src.At(sx, b.Min.Y+y)).(color.RGBA)
+			if purl && c.R > 0xe0 && c.G > 0xe0 && c.B > 0xe0 {
+				c = noStitchColor
+			}
+			dst.SetRGBA(x, y, c)
+		}
+	}
+	return dst, nil
+}
+
+// labelTile dibuja una casilla blanca con borde y el nombre del punto, del
+// tamaño de la casilla del punto derecho por cada punto que ocupa.
+func labelTile(st Stitch) (image.Image, error) {
+	knit, err := loadTile(getImagePath(&Knit{}))
+	if err != nil {
+		return nil, err
+	}
+	w, h := knit.Bounds().Dx()*max(st.weight(), 1), knit.Bounds().Dy()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+	outline(img, img.Bounds(), max(1, min(w, h)/20), color.Black)
+	label := st.String()
+	scale := 1
+	for textWidth(label, scale+1) <= w*3/4 && textHeight(scale+1) <= h/2 {
+		scale++
+	}
+	drawText(img, (w-textWidth(label, scale))/2, (h-textHeight(scale))/2, label, scale, color.Black)
+	return img, nil
+}
+
 func reverse[T any](list []T) []T {
     for i, j := 0, len(list)-1; i < j; {
         list[i], list[j] = list[j], list[i]
@@ -183,4 +654,3 @@ func reverse[T any](list []T) []T {
     }
     return list
 }
-
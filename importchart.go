@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Importador de gráficos en CSV o en texto separado por espacios: una fila del
+// gráfico por línea, casillas de izquierda a derecha tal y como se ven por el
+// derecho. Las filas se compilan con el Compiler, así que las cuentas de
+// puntos se comprueban igual que en un .knit.
+
+// ChartImportOptions describe cómo leer el gráfico.
+type ChartImportOptions struct {
+	Section    string
+	CSV        bool // casillas separadas por comas en vez de espacios
+	TopDown    bool // la primera línea es la fila 1; por defecto es la última, como en el gráfico
+	Round      bool // en redondo todas las filas son del derecho
+	FirstRowWS bool // en plano, la fila 1 es del revés
+}
+
+// Símbolos habituales de los gráficos, además de los puntos del lenguaje .knit
+// (k, p, yo, k2tog, c2r...). Las casillas "sin punto" se saltan.
+var chartSymbols = map[string]string{
+	"":   "k",
+	"|":  "k",
+	"-":  "p",
+	"•":  "p",
+	"o":  "yo",
+	"O":  "yo",
+	"/":  "k2tog",
+	"\\": "ssk",
+}
+
+var noStitchSymbols = map[string]bool{"x": true, "X": true, "ns": true}
+
+// Casilla de continuación de un punto que ocupa varias, como un cable.
+const continuationSymbol = "="
+
+// importChart lee el gráfico, monta los puntos de la primera fila y compila
+// todas las filas. Devuelve el compilador con las filas y el código .knit.
+func importChart(r io.Reader, opts ChartImportOptions) (*Compiler, string, error) {
+	lines, err := readChartCells(r, opts.CSV)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(lines) == 0 {
+		return nil, "", fmt.Errorf("empty chart")
+	}
+	if !opts.TopDown {
+		lines = reverse(lines)
+	}
+	if opts.Section == "" {
+		opts.Section = "chart"
+	}
+
+	var parsedRows []*ParsedRow
+	for i, cells := range lines {
+		rs := opts.Round || (i%2 == 0) != opts.FirstRowWS
+		row, err := chartRow(cells, rs)
+		if err != nil {
+			return nil, "", fmt.Errorf("chart row %d: %v", i+1, err)
+		}
+		parsedRows = append(parsedRows, row)
+	}
+
+	c := NewCompiler()
+	c.Section = opts.Section
+	castOn := 0
+	for _, expr := range parsedRows[0].Content {
+		st, err := c.compileStitch(expr.(ParsedStitch))
+		if err != nil {
+			return nil, "", err
+		}
+		castOn += st.advance()
+	}
+	if err := c.compileRow(&ParsedRow{Content: []ParsedExpr{&ParsedCo{Count: castOn}}}); err != nil {
+		return nil, "", err
+	}
+	for i, row := range parsedRows {
+		if err := c.compileRow(row); err != nil {
+			return nil, "", fmt.Errorf("chart row %d: %v", i+1, err)
+		}
+	}
+
+	src, err := decompileRows(opts.Section, c.Rows, false)
+	if err != nil {
+		return nil, "", err
+	}
+	return c, src, nil
+}
+
+func readChartCells(r io.Reader, isCSV bool) ([][]string, error) {
+	var lines [][]string
+	if isCSV {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+				continue
+			}
+			lines = append(lines, record)
+		}
+		return lines, nil
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	return lines, nil
+}
+
+// chartRow pasa las casillas de una fila a puntos en orden de tejido. Las
+// filas del derecho se tejen de derecha a izquierda; las del revés de
+// izquierda a derecha y con el punto contrario al que se ve (k por p,
+// k2tog por p2tog).
+func chartRow(cells []string, rs bool) (*ParsedRow, error) {
+	var sts []ParsedExpr
+	for col := 0; col < len(cells); col++ {
+		cell := strings.TrimSpace(cells[col])
+		if noStitchSymbols[cell] {
+			continue
+		}
+		if cell == continuationSymbol {
+			return nil, fmt.Errorf("column %d: %q without a stitch to continue", col+1, cell)
+		}
+		if symbol, ok := chartSymbols[cell]; ok {
+			cell = symbol
+		}
+
+		parser := NewParser(strings.NewReader(cell))
+		expr, err := parser.parseStitch()
+		if err != nil {
+			return nil, fmt.Errorf("column %d: unknown symbol %q", col+1, cell)
+		}
+		if _, tok, _ := parser.scan(); tok != EOF {
+			return nil, fmt.Errorf("column %d: unknown symbol %q", col+1, cell)
+		}
+		parsed, ok := expr.(ParsedStitch)
+		if !ok {
+			return nil, fmt.Errorf("column %d: %q is not a single stitch", col+1, cell)
+		}
+		st, err := NewCompiler().compileStitch(parsed)
+		if err != nil {
+			return nil, fmt.Errorf("column %d: %v", col+1, err)
+		}
+		if !rs {
+			if expr, err = wrongSide(expr); err != nil {
+				return nil, fmt.Errorf("column %d: %v", col+1, err)
+			}
+		}
+
+		// Un cable ocupa tantas casillas como puntos; las siguientes van con "=".
+		first, span := col, 0
+		for col+1 < len(cells) && strings.TrimSpace(cells[col+1]) == continuationSymbol {
+			col++
+			span++
+		}
+		if want := max(st.weight()-1, 0); span != want {
+			return nil, fmt.Errorf("column %d: %q takes %d cells, found %d", first+1, cell, want+1, span+1)
+		}
+		sts = append(sts, expr)
+	}
+	if len(sts) == 0 {
+		return nil, fmt.Errorf("row has no stitches")
+	}
+	if rs {
+		sts = reverse(sts)
+	}
+	return &ParsedRow{Content: sts}, nil
+}
+
+// wrongSide devuelve el punto que hay que tejer por el revés para que por el
+// derecho se vea como expr.
+func wrongSide(expr ParsedExpr) (ParsedExpr, error) {
+	switch s := expr.(type) {
+	case *ParsedKnit:
+		return &ParsedPurl{}, nil
+	case *ParsedPurl:
+		return &ParsedKnit{}, nil
+	case *ParsedKtog:
+		return &ParsedPtog{Count: s.Count}, nil
+	case *ParsedPtog:
+		return &ParsedKtog{Count: s.Count}, nil
+	case *ParsedYo:
+		return expr, nil
+	default:
+		return nil, fmt.Errorf("%s cannot be worked on a wrong side row", expr)
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Instrucciones escritas a partir del AST, con las cuentas del compilador
+// para resolver los *0 y *-N: "Row 3 (RS): *K2, (k2tog, yo) 4 times, k1; rep
+// from * to last 5 sts, k5. (27 sts)". Se pueden sacar en inglés o castellano.
+
+type Language string
+
+const (
+	English Language = "en"
+	Spanish Language = "es"
+)
+
+// vocabulary reúne las abreviaturas y frases de un idioma.
+type vocabulary struct {
+	section, row, rs, ws, sts string
+	castOn                    string // "Cast on %d sts."
+	times                     string // "%d times"
+	toEnd, toLast             string // "to end", "to last %d sts"
+	repFrom                   string // "rep from *"
+	repRows                   string // "Rep rows %d-%d"
+	onceMore, moreTimes       string // "once more", "%d more times"
+	glossaryTitle             string
+
+	run      func(abbr string, n int) string
+	knit     string
+	purl     string
+	ssk      string
+	ktog     string // con %d
+	ptog     string
+	yo       string
+	co, bo   string
+	pm, rm   string
+	cables   [4]string // RC, LC, purl RC, purl LC con %d/%d
+	glossary map[string]string
+}
+
+var vocabularies = map[Language]*vocabulary{
+	English: {
+		section: "Section", row: "Row", rs: "RS", ws: "WS", sts: "sts",
+		castOn:   "Cast on %d sts.",
+		times:    "%d times",
+		toEnd:    "to end",
+		toLast:   "to last %d sts",
+		repFrom:  "rep from *",
+		repRows:  "Rep rows %d-%d",
+		onceMore: "once more", moreTimes: "%d more times",
+		glossaryTitle: "Abbreviations",
+
+		run:  func(abbr string, n int) string { return fmt.Sprintf("%s%d", abbr, n) },
+		knit: "k", purl: "p", ssk: "ssk", ktog: "k%dtog", ptog: "p%dtog", yo: "yo",
+		co: "CO", bo: "BO", pm: "pm", rm: "rm",
+		cables: [4]string{"%d/%d RC", "%d/%d LC", "%d/%d RPC", "%d/%d LPC"},
+		glossary: map[string]string{
+			"k":    "knit",
+			"p":    "purl",
+			"ssk":  "slip 2 sts knitwise one at a time, knit them together through the back loop",
+			"ktog": "knit %d sts together",
+			"ptog": "purl %d sts together",
+			"yo":   "yarn over",
+			"CO":   "cast on",
+			"BO":   "bind off",
+			"pm":   "place marker",
+			"rm":   "remove marker",
+			"RC":   "right cross: slip %d sts to cable needle, hold in back, k%d, k the sts from cable needle",
+			"LC":   "left cross: slip %d sts to cable needle, hold in front, k%d, k the sts from cable needle",
+			"RPC":  "right purl cross: slip %d sts to cable needle, hold in back, k%d, p the sts from cable needle",
+			"LPC":  "left purl cross: slip %d sts to cable needle, hold in front, p%d, k the sts from cable needle",
+			"rep":  "repeat",
+			"RS":   "right side",
+			"WS":   "wrong side",
+			"sts":  "stitches",
+		},
+	},
+	Spanish: {
+		section: "Sección", row: "Fila", rs: "LD", ws: "LR", sts: "p",
+		castOn:   "Montar %d p.",
+		times:    "%d veces",
+		toEnd:    "hasta el final",
+		toLast:   "hasta los últimos %d p",
+		repFrom:  "rep desde *",
+		repRows:  "Rep las filas %d-%d",
+		onceMore: "una vez más", moreTimes: "%d veces más",
+		glossaryTitle: "Abreviaturas",
+
+		run:  func(abbr string, n int) string { return fmt.Sprintf("%d%s", n, abbr) },
+		knit: "d", purl: "r", ssk: "dsd", ktog: "%dpjd", ptog: "%dpjr", yo: "laz",
+		co: "mont", bo: "cerr", pm: "cm", rm: "qm",
+		cables: [4]string{"CD %d/%d", "CI %d/%d", "CDR %d/%d", "CIR %d/%d"},
+		glossary: map[string]string{
+			"d":    "derecho",
+			"r":    "revés",
+			"dsd":  "deslizar 2 p de uno en uno como del derecho y tejerlos juntos por detrás",
+			"ktog": "tejer %d p juntos al derecho",
+			"ptog": "tejer %d p juntos al revés",
+			"laz":  "lazada",
+			"mont": "montar",
+			"cerr": "cerrar",
+			"cm":   "colocar marcador",
+			"qm":   "quitar marcador",
+			"CD":   "cruce a la derecha: pasar %d p a la aguja auxiliar por detrás, %dd, tejer al derecho los p de la auxiliar",
+			"CI":   "cruce a la izquierda: pasar %d p a la aguja auxiliar por delante, %dd, tejer al derecho los p de la auxiliar",
+			"CDR":  "cruce a la derecha con revés: pasar %d p a la aguja auxiliar por detrás, %dd, tejer al revés los p de la auxiliar",
+			"CIR":  "cruce a la izquierda con revés: pasar %d p a la aguja auxiliar por delante, %dr, tejer al derecho los p de la auxiliar",
+			"rep":  "repetir",
+			"LD":   "lado derecho",
+			"LR":   "lado revés",
+			"p":    "punto(s)",
+		},
+	},
+}
+
+// instructionWriter genera el texto de un patrón y apunta las abreviaturas
+// usadas para el glosario.
+type instructionWriter struct {
+	v    *vocabulary
+	used map[string]string
+}
+
+// writtenInstructions devuelve las instrucciones escritas del patrón.
+func writtenInstructions(pattern *CompiledPattern, lang Language) (string, error) {
+	v, ok := vocabularies[lang]
+	if !ok {
+		return "", fmt.Errorf("unsupported language %q", lang)
+	}
+	w := &instructionWriter{v: v, used: map[string]string{}}
+
+	var out []string
+	out = append(out, pattern.Title())
+	c := NewCompiler()
+	for _, section := range pattern.Sections {
+		c.Section = section.Name
+		out = append(out, "", v.section+" "+section.Name)
+		n := 0
+		for _, node := range section.Content {
+			switch node := node.(type) {
+			case *ParsedRow:
+				line, err := w.row(c, node, &n)
+				if err != nil {
+					return "", fmt.Errorf("section %q, row %d: %v", section.Name, n, err)
+				}
+				out = append(out, line)
+			case *ParsedRepeatBlock:
+				first, last := n+1, n
+				for i := 0; i < node.Count; i++ {
+					for _, row := range node.Content {
+						line, err := w.row(c, row, &n)
+						if err != nil {
+							return "", fmt.Errorf("section %q, row %d: %v", section.Name, n, err)
+						}
+						if i == 0 {
+							out = append(out, line)
+						}
+					}
+					if i == 0 {
+						last = n
+					}
+				}
+				if node.Count > 1 && last >= first {
+					out = append(out, w.repeatRows(first, last, node.Count-1))
+				}
+			}
+		}
+	}
+
+	out = append(out, "", v.glossaryTitle)
+	out = append(out, w.glossary()...)
+	return strings.Join(out, "\n") + "\n", nil
+}
+
+func (w *instructionWriter) repeatRows(first, last, more int) string {
+	times := w.v.onceMore
+	if more > 1 {
+		times = fmt.Sprintf(w.v.moreTimes, more)
+	}
+	w.use("rep", w.v.glossary["rep"])
+	return fmt.Sprintf("%s %s.", fmt.Sprintf(w.v.repRows, first, last), times)
+}
+
+// topExpr es una expresión de primer nivel de la fila con lo que consume y los
+// puntos que quedan por tejer detrás de ella.
+type topExpr struct {
+	expr      ParsedExpr
+	remaining int
+}
+
+// row compila la fila igual que compileRow y la escribe. n lleva la cuenta de
+// filas trabajadas de la sección; el montaje no cuenta.
+func (w *instructionWriter) row(c *Compiler, parsedRow *ParsedRow, n *int) (string, error) {
+	// Mismo recorrido que compileRow, en un compilador aparte para no tocar
+	// la posición del de verdad. Sin fila anterior solo caben cuentas exactas
+	// y no queda nada por tejer detrás de cada expresión.
+	var tops []topExpr
+	scratch := &Compiler{LastRow: c.LastRow, Pos: CompilePosition{RowPos: c.Pos.RowPos, ColPos: 1}}
+	for _, parsedExpr := range parsedRow.Content {
+		switch parsedExpr.(type) {
+		case *PlaceMarker, *RemoveMarker:
+			tops = append(tops, topExpr{expr: parsedExpr})
+			continue
+		}
+		e, err := scratch.compileExpr(parsedExpr)
+		if err != nil {
+			return "", err
+		}
+		sts, err := scratch.expandExpr(e)
+		if err != nil {
+			return "", err
+		}
+		for _, st := range sts {
+			scratch.Pos.ColPos += st.advance()
+		}
+		top := topExpr{expr: parsedExpr}
+		if c.LastRow != nil {
+			top.remaining = c.LastRow.weight() - (scratch.Pos.ColPos - 1)
+		}
+		tops = append(tops, top)
+	}
+
+	if err := c.compileRow(parsedRow); err != nil {
+		return "", err
+	}
+	row := c.LastRow
+	if isCastOnRow(row) {
+		w.use(w.v.co, w.v.glossary[w.v.co])
+		return fmt.Sprintf(w.v.castOn, row.weight()), nil
+	}
+
+	*n++
+	side := w.v.ws
+	if *n%2 == 1 {
+		side = w.v.rs
+	}
+	w.use(side, w.v.glossary[side])
+	w.use(w.v.sts, w.v.glossary[w.v.sts])
+
+	var parts []string
+	var r run
+	for _, top := range tops {
+		if text, ok := w.fill(top); ok {
+			parts = r.flush(w, parts)
+			parts = append(parts, text)
+			continue
+		}
+		parts = w.appendExpr(parts, &r, top.expr)
+	}
+	parts = r.flush(w, parts)
+
+	return fmt.Sprintf("%s %d (%s): %s. (%d %s)", w.v.row, *n, side, capitalize(strings.Join(parts, ", ")), row.weight(), w.v.sts), nil
+}
+
+// fill escribe los *0 y *-N de primer nivel con "to end" o "to last N sts".
+func (w *instructionWriter) fill(top topExpr) (string, bool) {
+	var content ParsedExpr
+	switch r := top.expr.(type) {
+	case *ParsedRepeatExact:
+		if r.Count != 0 {
+			return "", false
+		}
+		content = r.Content
+	case *ParsedRepeatNeg:
+		content = r.Content
+	default:
+		return "", false
+	}
+
+	content = simplifyFill(content)
+	until := w.v.toEnd
+	if top.remaining > 0 {
+		until = fmt.Sprintf(w.v.toLast, top.remaining)
+	}
+	if abbr, ok := w.runAbbr(content); ok {
+		return abbr + " " + until, true
+	}
+	w.use("rep", w.v.glossary["rep"])
+	return "*" + strings.Join(w.exprs(unwrapGroup(content)), ", ") + "; " + w.v.repFrom + " " + until, true
+}
+
+// run acumula puntos derechos o reveses seguidos para escribirlos como k3.
+type run struct {
+	abbr string
+	n    int
+}
+
+func (r *run) flush(w *instructionWriter, parts []string) []string {
+	if r.n > 0 {
+		parts = append(parts, w.v.run(r.abbr, r.n))
+	}
+	r.n = 0
+	return parts
+}
+
+func (w *instructionWriter) exprs(exprs []ParsedExpr) []string {
+	var parts []string
+	var r run
+	for _, expr := range exprs {
+		parts = w.appendExpr(parts, &r, expr)
+	}
+	return r.flush(w, parts)
+}
+
+func (w *instructionWriter) appendExpr(parts []string, r *run, expr ParsedExpr) []string {
+	abbr, count := "", 0
+	switch e := expr.(type) {
+	case *ParsedKnit, *ParsedPurl:
+		abbr, _ = w.runAbbr(e)
+		count = 1
+	case *ParsedRepeatExact:
+		if a, ok := w.runAbbr(e.Content); ok && e.Count > 0 {
+			abbr, count = a, e.Count
+		}
+	}
+	if count > 0 {
+		if r.n > 0 && r.abbr != abbr {
+			parts = r.flush(w, parts)
+		}
+		r.abbr = abbr
+		r.n += count
+		return parts
+	}
+
+	parts = r.flush(w, parts)
+	switch e := expr.(type) {
+	case *ParsedGroup:
+		return append(parts, w.exprs(e.Content)...)
+	case *ParsedRepeatExact:
+		inner := strings.Join(w.exprs(unwrapGroup(e.Content)), ", ")
+		switch e.Count {
+		case 0:
+			w.use("rep", w.v.glossary["rep"])
+			return append(parts, "*"+inner+"; "+w.v.repFrom)
+		case 1:
+			return append(parts, inner)
+		default:
+			return append(parts, "("+inner+") "+fmt.Sprintf(w.v.times, e.Count))
+		}
+	case *ParsedRepeatNeg:
+		w.use("rep", w.v.glossary["rep"])
+		inner := strings.Join(w.exprs(unwrapGroup(e.Content)), ", ")
+		return append(parts, "*"+inner+"; "+w.v.repFrom+" "+fmt.Sprintf(w.v.toLast, e.Count))
+	default:
+		return append(parts, w.stitch(expr))
+	}
+}
+
+// runAbbr devuelve la abreviatura si expr es un punto derecho o revés suelto.
+func (w *instructionWriter) runAbbr(expr ParsedExpr) (string, bool) {
+	switch expr.(type) {
+	case *ParsedKnit:
+		w.use(w.v.knit, w.v.glossary[w.v.knit])
+		return w.v.knit, true
+	case *ParsedPurl:
+		w.use(w.v.purl, w.v.glossary[w.v.purl])
+		return w.v.purl, true
+	}
+	return "", false
+}
+
+func (w *instructionWriter) stitch(expr ParsedExpr) string {
+	v := w.v
+	switch s := expr.(type) {
+	case *ParsedSsk:
+		w.use(v.ssk, v.glossary[v.ssk])
+		return v.ssk
+	case *ParsedKtog:
+		abbr := fmt.Sprintf(v.ktog, s.Count)
+		w.use(abbr, fmt.Sprintf(v.glossary["ktog"], s.Count))
+		return abbr
+	case *ParsedPtog:
+		abbr := fmt.Sprintf(v.ptog, s.Count)
+		w.use(abbr, fmt.Sprintf(v.glossary["ptog"], s.Count))
+		return abbr
+	case *ParsedYo:
+		w.use(v.yo, v.glossary[v.yo])
+		return v.yo
+	case *ParsedCo:
+		w.use(v.co, v.glossary[v.co])
+		return fmt.Sprintf("%s %d", v.co, s.Count)
+	case *ParsedBo:
+		w.use(v.bo, v.glossary[v.bo])
+		return fmt.Sprintf("%s %d", v.bo, s.Count)
+	case *ParsedCableRC:
+		return w.cable(0, s.FrontCount, s.BackCount)
+	case *ParsedCableLC:
+		return w.cable(1, s.FrontCount, s.BackCount)
+	case *ParsedPurlCableRC:
+		return w.cable(2, s.FrontCount, s.BackCount)
+	case *ParsedPurlCableLC:
+		return w.cable(3, s.FrontCount, s.BackCount)
+	case *PlaceMarker:
+		w.use(v.pm, v.glossary[v.pm])
+		return v.pm + " " + s.Name
+	case *RemoveMarker:
+		w.use(v.rm, v.glossary[v.rm])
+		return v.rm + " " + s.Name
+	default:
+		return expr.String()
+	}
+}
+
+func (w *instructionWriter) cable(kind, front, back int) string {
+	abbr := fmt.Sprintf(w.v.cables[kind], front, back)
+	name := strings.Fields(strings.ReplaceAll(w.v.cables[kind], "%d/%d", ""))[0]
+	w.use(abbr, fmt.Sprintf(w.v.glossary[name], back, front))
+	return abbr
+}
+
+func (w *instructionWriter) use(abbr, meaning string) {
+	if meaning != "" {
+		w.used[abbr] = meaning
+	}
+}
+
+func (w *instructionWriter) glossary() []string {
+	var abbrs []string
+	for abbr := range w.used {
+		abbrs = append(abbrs, abbr)
+	}
+	sort.Strings(abbrs)
+	var lines []string
+	for _, abbr := range abbrs {
+		lines = append(lines, fmt.Sprintf("%-10s %s", abbr, w.used[abbr]))
+	}
+	return lines
+}
+
+// simplifyFill quita los grupos de un solo elemento y los *0 anidados dentro
+// de otro *0, que tejen lo mismo: (k*0)*0 es "k to end".
+func simplifyFill(expr ParsedExpr) ParsedExpr {
+	for {
+		switch e := expr.(type) {
+		case *ParsedGroup:
+			if len(e.Content) != 1 {
+				return expr
+			}
+			expr = e.Content[0]
+		case *ParsedRepeatExact:
+			if e.Count != 0 {
+				return expr
+			}
+			expr = e.Content
+		default:
+			return expr
+		}
+	}
+}
+
+// unwrapGroup devuelve el contenido de un grupo o la expresión sola.
+func unwrapGroup(expr ParsedExpr) []ParsedExpr {
+	if g, ok := expr.(*ParsedGroup); ok {
+		return g.Content
+	}
+	return []ParsedExpr{expr}
+}
+
+// capitalize pone en mayúscula la primera letra si la frase empieza por ella
+// (o por el * de una repetición): "k2, yo" -> "K2, yo", pero "2d" se queda igual.
+func capitalize(s string) string {
+	prefix := ""
+	if strings.HasPrefix(s, "*") {
+		prefix, s = "*", s[1:]
+	}
+	for i, r := range s {
+		if unicode.IsLetter(r) {
+			return prefix + string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+		}
+		break
+	}
+	return prefix + s
+}
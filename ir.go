@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Representación intermedia (IR) del patrón compilado en JSON, para que otras
+// herramientas puedan leer las filas sin volver a parsear el .knit. El campo
+// version cambia cuando cambia el formato; al importar se rechazan versiones
+// más nuevas que irVersion.
+
+const (
+	irFormat  = "goknit-ir"
+	irVersion = 1
+)
+
+type irPattern struct {
+	Format   string            `json:"format"`
+	Version  int               `json:"version"`
+	Name     string            `json:"name"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Sections []irSection       `json:"sections"`
+}
+
+type irSection struct {
+	Name string  `json:"name"`
+	Rows []irRow `json:"rows"`
+}
+
+type irRow struct {
+	Number   int        `json:"number"`         // número de fila compilada, contando el montaje
+	Row      int        `json:"row,omitempty"`  // número en el patrón escrito; 0 en el montaje
+	Side     string     `json:"side,omitempty"` // "RS", "WS" o vacío en el montaje
+	Shaping  bool       `json:"shaping,omitempty"`
+	Stitches []irStitch `json:"stitches"`
+	Markers  []irMarker `json:"markers,omitempty"`
+	Span     *irSpan    `json:"span,omitempty"`
+	Block    *irBlock   `json:"block,omitempty"`
+}
+
+type irStitch struct {
+	Type     string         `json:"type"`
+	Consumes int            `json:"consumes"`
+	Produces int            `json:"produces"`
+	Params   map[string]int `json:"params,omitempty"`
+}
+
+type irMarker struct {
+	Name   string `json:"name"`
+	At     int    `json:"at"`
+	Action string `json:"action"` // "place" o "remove"
+}
+
+// irBlock es el bloque "repeat" del que sale la fila; ver RowBlock.
+type irBlock struct {
+	Id        int `json:"id"`
+	Iteration int `json:"iteration"`
+	Times     int `json:"times"`
+	Index     int `json:"index"`
+	Size      int `json:"size"`
+}
+
+type irSpan struct {
+	StartLine   int `json:"start_line"`
+	StartColumn int `json:"start_column"`
+	EndLine     int `json:"end_line"`
+	EndColumn   int `json:"end_column"`
+}
+
+// irStitchOf describe un punto compilado con su tipo y sus parámetros.
+func irStitchOf(st Stitch) (irStitch, error) {
+	out := irStitch{Consumes: st.advance(), Produces: st.weight()}
+	cable := func(kind string, front, back int) {
+		out.Type = kind
+		out.Params = map[string]int{"front": front, "back": back}
+	}
+	switch s := st.(type) {
+	case *Knit:
+		out.Type = "k"
+	case *Purl:
+		out.Type = "p"
+	case *Ssk:
+		out.Type = "ssk"
+	case *Yo:
+		out.Type = "yo"
+	case *Ktog:
+		out.Type, out.Params = "ktog", map[string]int{"count": s.Count}
+	case *Ptog:
+		out.Type, out.Params = "ptog", map[string]int{"count": s.Count}
+	case *Co:
+		out.Type, out.Params = "co", map[string]int{"count": s.Count}
+	case *Bo:
+		out.Type, out.Params = "bo", map[string]int{"count": s.Count}
+	case *CableRC:
+		cable("cable-rc", s.FrontCount, s.BackCount)
+	case *CableLC:
+		cable("cable-lc", s.FrontCount, s.BackCount)
+	case *PurlCableRC:
+		cable("purl-cable-rc", s.FrontCount, s.BackCount)
+	case *PurlCableLC:
+		cable("purl-cable-lc", s.FrontCount, s.BackCount)
+	default:
+		return out, fmt.Errorf("unknown stitch %T", st)
+	}
+	return out, nil
+}
+
+// stitch reconstruye el punto compilado y comprueba que las cuentas guardadas
+// coinciden con las del punto.
+func (s irStitch) stitch() (Stitch, error) {
+	var st Stitch
+	switch s.Type {
+	case "k":
+		st = &Knit{}
+	case "p":
+		st = &Purl{}
+	case "ssk":
+		st = &Ssk{}
+	case "yo":
+		st = &Yo{}
+	case "ktog":
+		st = &Ktog{Count: s.Params["count"]}
+	case "ptog":
+		st = &Ptog{Count: s.Params["count"]}
+	case "co":
+		st = &Co{Count: s.Params["count"]}
+	case "bo":
+		st = &Bo{Count: s.Params["count"]}
+	case "cable-rc":
+		st = &CableRC{FrontCount: s.Params["front"], BackCount: s.Params["back"]}
+	case "cable-lc":
+		st = &CableLC{FrontCount: s.Params["front"], BackCount: s.Params["back"]}
+	case "purl-cable-rc":
+		st = &PurlCableRC{FrontCount: s.Params["front"], BackCount: s.Params["back"]}
+	case "purl-cable-lc":
+		st = &PurlCableLC{FrontCount: s.Params["front"], BackCount: s.Params["back"]}
+	default:
+		return nil, fmt.Errorf("unknown stitch type %q", s.Type)
+	}
+	if st.advance() != s.Consumes || st.weight() != s.Produces {
+		return nil, fmt.Errorf("%s consumes %d and produces %d, not %d and %d",
+			s.Type, st.advance(), st.weight(), s.Consumes, s.Produces)
+	}
+	return st, nil
+}
+
+// exportIR escribe el patrón compilado como JSON.
+func exportIR(pattern *CompiledPattern, w io.Writer) error {
+	out := irPattern{Format: irFormat, Version: irVersion, Name: pattern.Name, Meta: pattern.Meta}
+	for _, section := range pattern.Sections {
+		sec := irSection{Name: section.Name, Rows: []irRow{}}
+		for _, pr := range pattern.PatternRows(section.Name) {
+			row := irRow{Number: pr.Row.Number, Row: pr.Index, Shaping: pr.Row.Shaping}
+			if !pr.CastOn {
+				row.Side = "WS"
+				if pr.RS {
+					row.Side = "RS"
+				}
+			}
+			for i, st := range pr.Row.Stitches {
+				s, err := irStitchOf(st)
+				if err != nil {
+					return fmt.Errorf("row %d, st %d: %v", pr.Row.Number, i+1, err)
+				}
+				row.Stitches = append(row.Stitches, s)
+			}
+			for _, m := range pr.Row.Markers {
+				action := "place"
+				if m.Remove {
+					action = "remove"
+				}
+				row.Markers = append(row.Markers, irMarker{Name: m.Name, At: m.At, Action: action})
+			}
+			if span := pr.Row.Span; span.Start.Line() > 0 {
+				row.Span = &irSpan{span.Start.Line(), span.Start.Column(), span.End.Line(), span.End.Column()}
+			}
+			if b := pr.Row.Block; b != nil {
+				row.Block = &irBlock{b.Id, b.Iteration, b.Times, b.Index, b.Size}
+			}
+			sec.Rows = append(sec.Rows, row)
+		}
+		out.Sections = append(out.Sections, sec)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// importIR lee el JSON y reconstruye Compiler.Rows, comprobando que cada fila
+// teje los puntos que dejó la anterior.
+func importIR(r io.Reader) (*CompiledPattern, error) {
+	var in irPattern
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return nil, err
+	}
+	if in.Format != irFormat {
+		return nil, fmt.Errorf("not a %s file (format %q)", irFormat, in.Format)
+	}
+	if in.Version < 1 || in.Version > irVersion {
+		return nil, fmt.Errorf("unsupported %s version %d (supported up to %d)", irFormat, in.Version, irVersion)
+	}
+
+	c := NewCompiler()
+	pattern := &CompiledPattern{Name: in.Name, Meta: in.Meta, Compiler: c}
+	if pattern.Meta == nil {
+		pattern.Meta = map[string]string{}
+	}
+	for _, sec := range in.Sections {
+		pattern.Sections = append(pattern.Sections, &Section{Name: sec.Name})
+		c.Section = sec.Name
+		for _, ir := range sec.Rows {
+			// Los números pueden saltar si el compilador descartó alguna fila,
+			// pero siempre crecen.
+			if ir.Number <= c.Pos.RowPos {
+				return nil, fmt.Errorf("section %q: row %d after row %d", sec.Name, ir.Number, c.Pos.RowPos)
+			}
+			c.Pos.RowPos = ir.Number - 1
+			c.startNewRow()
+			row := c.CurrentRow
+			row.Shaping = ir.Shaping
+			for i, s := range ir.Stitches {
+				st, err := s.stitch()
+				if err != nil {
+					return nil, fmt.Errorf("row %d, st %d: %v", row.Number, i+1, err)
+				}
+				row.Stitches = append(row.Stitches, st)
+			}
+			for _, m := range ir.Markers {
+				if m.At < 0 || m.At > len(row.Stitches) || (m.Action != "place" && m.Action != "remove") {
+					return nil, fmt.Errorf("row %d: invalid marker %+v", row.Number, m)
+				}
+				row.Markers = append(row.Markers, Marker{Name: m.Name, At: m.At, Remove: m.Action == "remove"})
+			}
+			if ir.Span != nil {
+				row.Span = Span{
+					Start: Position{line: ir.Span.StartLine, column: ir.Span.StartColumn},
+					End:   Position{line: ir.Span.EndLine, column: ir.Span.EndColumn},
+				}
+			}
+			if b := ir.Block; b != nil {
+				if b.Iteration < 1 || b.Iteration > b.Times || b.Index < 1 || b.Index > b.Size {
+					return nil, fmt.Errorf("row %d: invalid block %+v", row.Number, *b)
+				}
+				row.Block = &RowBlock{Id: b.Id, Iteration: b.Iteration, Times: b.Times, Index: b.Index, Size: b.Size}
+			}
+			if c.LastRow != nil && c.LastRow.weight() != row.advance() {
+				return nil, fmt.Errorf("row %d: unmatch number of stitches. Expected: %d, Received: %d",
+					row.Number, c.LastRow.weight(), row.advance())
+			}
+			c.Rows = append(c.Rows, row)
+			c.LastRow = row
+		}
+	}
+	return pattern, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Diario de una sesión: cada vez que se cambia de fila en la TUI se añade una
+// entrada con la hora. Con el diario se calcula el ritmo (filas por hora), el
+// tiempo tejido en cada sección y cuánto falta para acabar el patrón.
+
+// JournalEntry es un cambio de fila. Action es "advance" o "retreat" si se
+// pasa a la fila siguiente o a la anterior y "jump" si se salta a otra fila.
+// Section es la sección de la fila From.
+type JournalEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	From    int       `json:"from"`
+	To      int       `json:"to"`
+	Section string    `json:"section"`
+}
+
+// Un hueco de más de journalIdle entre dos entradas es que se dejó de tejer y
+// no cuenta como tiempo tejido.
+const journalIdle = 30 * time.Minute
+
+// journalFile es el archivo del diario de la sesión file.
+func journalFile(file string) string {
+	return strings.TrimSuffix(file, ".json") + ".journal"
+}
+
+// SectionTime es el tiempo tejido en una sección.
+type SectionTime struct {
+	Section string
+	Time    time.Duration
+	Rows    int
+}
+
+type JournalStats struct {
+	Entries     int
+	Advanced    int
+	Retreated   int
+	Active      time.Duration // tiempo tejido, sin las pausas
+	RowsPerHour float64
+	Sections    []SectionTime // en el orden en que se tejieron
+	Remaining   int           // filas que quedan hasta el final del patrón
+	ETA         time.Duration // 0 si aún no hay ritmo
+}
+
+// journalStats resume el diario. remaining son las filas que faltan desde la
+// fila actual.
+func journalStats(entries []JournalEntry, remaining int) JournalStats {
+	stats := JournalStats{Entries: len(entries), Remaining: max(remaining, 0)}
+	bySection := map[string]int{}
+	for i, e := range entries {
+		switch e.Action {
+		case "advance":
+			stats.Advanced++
+		case "retreat":
+			stats.Retreated++
+		}
+		k, ok := bySection[e.Section]
+		if !ok {
+			k = len(stats.Sections)
+			bySection[e.Section] = k
+			stats.Sections = append(stats.Sections, SectionTime{Section: e.Section})
+		}
+		if e.Action == "advance" {
+			stats.Sections[k].Rows++
+		}
+		// El tiempo desde la entrada anterior se pasó tejiendo la fila From.
+		if i > 0 {
+			if gap := e.Time.Sub(entries[i-1].Time); gap > 0 && gap <= journalIdle {
+				stats.Active += gap
+				stats.Sections[k].Time += gap
+			}
+		}
+	}
+	if stats.Active > 0 && stats.Advanced > 0 {
+		stats.RowsPerHour = float64(stats.Advanced) / stats.Active.Hours()
+		stats.ETA = time.Duration(float64(stats.Remaining) / stats.RowsPerHour * float64(time.Hour))
+	}
+	return stats
+}
+
+func (s JournalStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d entries: %d rows forward, %d back\n", s.Entries, s.Advanced, s.Retreated)
+	fmt.Fprintf(&b, "Knitting time: %s\n", s.Active.Round(time.Minute))
+	if s.RowsPerHour > 0 {
+		fmt.Fprintf(&b, "Pace: %.1f rows/hour\n", s.RowsPerHour)
+	} else {
+		b.WriteString("Pace: not enough entries yet\n")
+	}
+	for _, sec := range s.Sections {
+		fmt.Fprintf(&b, "  %s: %d rows in %s\n", sec.Section, sec.Rows, sec.Time.Round(time.Minute))
+	}
+	fmt.Fprintf(&b, "Remaining: %d rows", s.Remaining)
+	if s.ETA > 0 {
+		fmt.Fprintf(&b, ", about %s of knitting", s.ETA.Round(time.Minute))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// writeJournalCSV escribe el diario en CSV con cabecera.
+func writeJournalCSV(w io.Writer, entries []JournalEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "action", "from", "to", "section"})
+	for _, e := range entries {
+		out.Write([]string{
+			e.Time.Format(time.RFC3339),
+			e.Action,
+			strconv.Itoa(e.From),
+			strconv.Itoa(e.To),
+			e.Section,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Importación y exportación de KnitML. Cada Section es un instruction-group,
+// las filas van dentro de instruction, los *N / *0 / *-N son repeat con
+// until="times", "end" o "before-end", y los bloques "repeat N { }" son una
+// instruction seguida de un repeat-instruction que la referencia.
+
+const knitmlNamespace = "http://www.knitml.com/schema/pattern"
+
+// xmlNode es un elemento XML genérico, para no atar el código a un esquema.
+type xmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []xmlNode  `xml:",any"`
+}
+
+func newNode(name string, attrs ...string) xmlNode {
+	n := xmlNode{XMLName: xml.Name{Local: name}}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		n.Attrs = append(n.Attrs, xml.Attr{Name: xml.Name{Local: attrs[i]}, Value: attrs[i+1]})
+	}
+	return n
+}
+
+func (n xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n xmlNode) intAttr(name string, def int) (int, error) {
+	value := n.attr(name)
+	if value == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("<%s %s=%q>: %v", n.XMLName.Local, name, value, err)
+	}
+	return i, nil
+}
+
+// ------------------------------------------------------------ Exportación
+
+func knitmlExport(pattern *CompiledPattern) ([]byte, error) {
+	root := newNode("pattern", "xmlns", knitmlNamespace, "version", "0.7")
+
+	info := newNode("general-information")
+	name := newNode("name")
+	name.Text = pattern.Title()
+	info.Nodes = append(info.Nodes, name)
+	var keys []string
+	for key := range pattern.Meta {
+		if key != "title" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		note := newNode("note", "key", key)
+		note.Text = pattern.Meta[key]
+		info.Nodes = append(info.Nodes, note)
+	}
+	root.Nodes = append(root.Nodes, info)
+
+	directives := newNode("directives")
+	for _, section := range pattern.Sections {
+		group, err := knitmlSection(section)
+		if err != nil {
+			return nil, fmt.Errorf("section %q: %v", section.Name, err)
+		}
+		directives.Nodes = append(directives.Nodes, group)
+	}
+	root.Nodes = append(root.Nodes, directives)
+
+	out, err := xml.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(out, '\n')...), nil
+}
+
+func knitmlSection(section *Section) (xmlNode, error) {
+	group := newNode("instruction-group", "id", section.Name)
+	sec := newNode("section")
+
+	var pending []*ParsedRow
+	n := 0
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		n++
+		instruction, err := knitmlInstruction(fmt.Sprintf("%s-%d", section.Name, n), pending)
+		sec.Nodes = append(sec.Nodes, instruction)
+		pending = nil
+		return err
+	}
+
+	for _, node := range section.Content {
+		switch node := node.(type) {
+		case *ParsedRow:
+			pending = append(pending, node)
+		case *ParsedRepeatBlock:
+			if err := flush(); err != nil {
+				return group, err
+			}
+			n++
+			id := fmt.Sprintf("%s-%d", section.Name, n)
+			instruction, err := knitmlInstruction(id, node.Content)
+			if err != nil {
+				return group, err
+			}
+			sec.Nodes = append(sec.Nodes, instruction)
+			if node.Count > 1 {
+				repeat := newNode("repeat-instruction", "ref", id)
+				times := newNode("until-additional-times")
+				times.Text = strconv.Itoa(node.Count - 1)
+				repeat.Nodes = append(repeat.Nodes, times)
+				sec.Nodes = append(sec.Nodes, repeat)
+			}
+		default:
+			return group, fmt.Errorf("unsupported node %T", node)
+		}
+	}
+	if err := flush(); err != nil {
+		return group, err
+	}
+	group.Nodes = append(group.Nodes, sec)
+	return group, nil
+}
+
+func knitmlInstruction(id string, rows []*ParsedRow) (xmlNode, error) {
+	instruction := newNode("instruction", "id", id)
+	for _, row := range rows {
+		// Una fila que solo monta puntos es un cast-on de KnitML.
+		if len(row.Content) == 1 && !row.Shaping {
+			if co, ok := row.Content[0].(*ParsedCo); ok {
+				instruction.Nodes = append(instruction.Nodes, newNode("cast-on", "count", strconv.Itoa(co.Count)))
+				continue
+			}
+		}
+		r := newNode("row")
+		// KnitML no tiene nada para "shaping"; va como atributo de la fila.
+		if row.Shaping {
+			r = newNode("row", "shaping", "true")
+		}
+		for _, expr := range row.Content {
+			node, err := knitmlExpr(expr)
+			if err != nil {
+				return instruction, err
+			}
+			r.Nodes = append(r.Nodes, node...)
+		}
+		instruction.Nodes = append(instruction.Nodes, r)
+	}
+	return instruction, nil
+}
+
+func knitmlExpr(expr ParsedExpr) ([]xmlNode, error) {
+	count := func(name string, n int) xmlNode {
+		node := newNode(name)
+		node.Text = strconv.Itoa(n)
+		return node
+	}
+	cable := func(front, back int, kind, nextType string) xmlNode {
+		return newNode("cross-stitches", "first", strconv.Itoa(front), "next", strconv.Itoa(back), "type", kind, "next-type", nextType)
+	}
+	repeat := func(content ParsedExpr, attrs ...string) ([]xmlNode, error) {
+		node := newNode("repeat", attrs...)
+		for _, e := range unwrapGroup(content) {
+			children, err := knitmlExpr(e)
+			if err != nil {
+				return nil, err
+			}
+			node.Nodes = append(node.Nodes, children...)
+		}
+		return []xmlNode{node}, nil
+	}
+
+	switch e := expr.(type) {
+	case *ParsedKnit:
+		return []xmlNode{count("knit", 1)}, nil
+	case *ParsedPurl:
+		return []xmlNode{count("purl", 1)}, nil
+	case *ParsedSsk:
+		return []xmlNode{newNode("decrease", "type", "ssk")}, nil
+	case *ParsedKtog:
+		return []xmlNode{newNode("decrease", "type", fmt.Sprintf("k%dtog", e.Count))}, nil
+	case *ParsedPtog:
+		return []xmlNode{newNode("decrease", "type", fmt.Sprintf("p%dtog", e.Count))}, nil
+	case *ParsedYo:
+		return []xmlNode{newNode("increase", "type", "yo")}, nil
+	case *ParsedCo:
+		return []xmlNode{newNode("cast-on", "count", strconv.Itoa(e.Count))}, nil
+	case *ParsedBo:
+		return []xmlNode{newNode("bind-off", "count", strconv.Itoa(e.Count))}, nil
+	case *ParsedCableRC:
+		return []xmlNode{cable(e.FrontCount, e.BackCount, "back", "knit")}, nil
+	case *ParsedCableLC:
+		return []xmlNode{cable(e.FrontCount, e.BackCount, "front", "knit")}, nil
+	case *ParsedPurlCableRC:
+		return []xmlNode{cable(e.FrontCount, e.BackCount, "back", "purl")}, nil
+	case *ParsedPurlCableLC:
+		return []xmlNode{cable(e.FrontCount, e.BackCount, "front", "purl")}, nil
+	case *PlaceMarker:
+		return []xmlNode{newNode("place-marker", "label", e.Name)}, nil
+	case *RemoveMarker:
+		return []xmlNode{newNode("remove-marker", "label", e.Name)}, nil
+	case *ParsedGroup:
+		var nodes []xmlNode
+		for _, sub := range e.Content {
+			children, err := knitmlExpr(sub)
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, children...)
+		}
+		return nodes, nil
+	case *ParsedRepeatExact:
+		// k5 y k*5 son <knit>5</knit>.
+		switch e.Content.(type) {
+		case *ParsedKnit:
+			if e.Count > 0 {
+				return []xmlNode{count("knit", e.Count)}, nil
+			}
+		case *ParsedPurl:
+			if e.Count > 0 {
+				return []xmlNode{count("purl", e.Count)}, nil
+			}
+		}
+		if e.Count == 0 {
+			return repeat(e.Content, "until", "end")
+		}
+		return repeat(e.Content, "until", "times", "value", strconv.Itoa(e.Count))
+	case *ParsedRepeatNeg:
+		return repeat(e.Content, "until", "before-end", "value", strconv.Itoa(e.Count))
+	default:
+		return nil, fmt.Errorf("cannot export %T to KnitML", expr)
+	}
+}
+
+// ------------------------------------------------------------ Importación
+
+// knitmlImport lee un documento KnitML y devuelve el patrón parseado y compilado.
+func knitmlImport(name string, r io.Reader) (*CompiledPattern, error) {
+	var root xmlNode
+	if err := xml.NewDecoder(r).Decode(&root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "pattern" {
+		return nil, fmt.Errorf("expected <pattern>, got <%s>", root.XMLName.Local)
+	}
+
+	meta := map[string]string{}
+	var sections []*Section
+	for _, child := range root.Nodes {
+		switch child.XMLName.Local {
+		case "general-information":
+			for _, info := range child.Nodes {
+				switch info.XMLName.Local {
+				case "name":
+					meta["title"] = strings.TrimSpace(info.Text)
+				case "note":
+					meta[info.attr("key")] = strings.TrimSpace(info.Text)
+				}
+			}
+		case "directives":
+			for _, group := range child.Nodes {
+				if group.XMLName.Local != "instruction-group" {
+					continue
+				}
+				section, err := knitmlReadGroup(group)
+				if err != nil {
+					return nil, err
+				}
+				sections = append(sections, section)
+			}
+		}
+	}
+
+	c := NewCompiler()
+	for _, section := range sections {
+		if err := c.compileSection(section); err != nil {
+			return nil, err
+		}
+	}
+	return &CompiledPattern{Name: name, Meta: meta, Sections: sections, Compiler: c}, nil
+}
+
+func knitmlReadGroup(group xmlNode) (*Section, error) {
+	section := &Section{Name: group.attr("id")}
+	if section.Name == "" {
+		return nil, fmt.Errorf("<instruction-group> without id")
+	}
+
+	var instructions []xmlNode
+	for _, sec := range group.Nodes {
+		if sec.XMLName.Local == "section" {
+			instructions = append(instructions, sec.Nodes...)
+		}
+	}
+
+	for i := 0; i < len(instructions); i++ {
+		node := instructions[i]
+		if node.XMLName.Local != "instruction" {
+			return nil, fmt.Errorf("section %q: unexpected <%s>", section.Name, node.XMLName.Local)
+		}
+		rows, err := knitmlReadRows(node)
+		if err != nil {
+			return nil, fmt.Errorf("section %q, instruction %q: %v", section.Name, node.attr("id"), err)
+		}
+
+		// Si la siguiente es un repeat-instruction de esta, es un bloque.
+		if i+1 < len(instructions) && instructions[i+1].XMLName.Local == "repeat-instruction" &&
+			instructions[i+1].attr("ref") == node.attr("id") {
+			i++
+			more := 0
+			for _, until := range instructions[i].Nodes {
+				if until.XMLName.Local == "until-additional-times" {
+					if more, err = strconv.Atoi(strings.TrimSpace(until.Text)); err != nil {
+						return nil, fmt.Errorf("section %q: invalid repeat count %q", section.Name, until.Text)
+					}
+				}
+			}
+			section.Content = append(section.Content, &ParsedRepeatBlock{Content: rows, Count: more + 1})
+			continue
+		}
+		for _, row := range rows {
+			section.Content = append(section.Content, row)
+		}
+	}
+	return section, nil
+}
+
+func knitmlReadRows(instruction xmlNode) ([]*ParsedRow, error) {
+	var rows []*ParsedRow
+	for _, node := range instruction.Nodes {
+		switch node.XMLName.Local {
+		case "cast-on":
+			co, err := knitmlReadExpr(node)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, &ParsedRow{Content: co})
+		case "row":
+			row := &ParsedRow{}
+			switch shaping := node.attr("shaping"); shaping {
+			case "true":
+				row.Shaping = true
+			case "", "false":
+			default:
+				return nil, fmt.Errorf("row %d: invalid shaping %q", len(rows)+1, shaping)
+			}
+			for _, child := range node.Nodes {
+				exprs, err := knitmlReadExpr(child)
+				if err != nil {
+					return nil, fmt.Errorf("row %d: %v", len(rows)+1, err)
+				}
+				row.Content = append(row.Content, exprs...)
+			}
+			rows = append(rows, row)
+		default:
+			return nil, fmt.Errorf("unexpected <%s>", node.XMLName.Local)
+		}
+	}
+	return rows, nil
+}
+
+func knitmlReadExpr(node xmlNode) ([]ParsedExpr, error) {
+	name := node.XMLName.Local
+	switch name {
+	case "knit", "purl":
+		n := 1
+		if text := strings.TrimSpace(node.Text); text != "" {
+			var err error
+			if n, err = strconv.Atoi(text); err != nil {
+				return nil, fmt.Errorf("<%s>%s</%s>: %v", name, text, name, err)
+			}
+		}
+		var st ParsedExpr = &ParsedKnit{}
+		if name == "purl" {
+			st = &ParsedPurl{}
+		}
+		if n == 1 {
+			return []ParsedExpr{st}, nil
+		}
+		return []ParsedExpr{&ParsedRepeatExact{Content: st, Count: n}}, nil
+	case "decrease":
+		kind := node.attr("type")
+		var count int
+		switch {
+		case kind == "ssk":
+			return []ParsedExpr{&ParsedSsk{}}, nil
+		case isKtog(kind):
+			fmt.Sscanf(kind, "k%dtog", &count)
+			return []ParsedExpr{&ParsedKtog{Count: count}}, nil
+		case isPtog(kind):
+			fmt.Sscanf(kind, "p%dtog", &count)
+			return []ParsedExpr{&ParsedPtog{Count: count}}, nil
+		}
+		return nil, fmt.Errorf("unsupported decrease %q", kind)
+	case "increase":
+		if kind := node.attr("type"); kind != "yo" {
+			return nil, fmt.Errorf("unsupported increase %q", kind)
+		}
+		return []ParsedExpr{&ParsedYo{}}, nil
+	case "cast-on", "bind-off":
+		count, err := node.intAttr("count", 0)
+		if err != nil {
+			return nil, err
+		}
+		if name == "cast-on" {
+			return []ParsedExpr{&ParsedCo{Count: count}}, nil
+		}
+		return []ParsedExpr{&ParsedBo{Count: count}}, nil
+	case "cross-stitches":
+		front, err := node.intAttr("first", 0)
+		if err != nil {
+			return nil, err
+		}
+		back, err := node.intAttr("next", front)
+		if err != nil {
+			return nil, err
+		}
+		purl := node.attr("next-type") == "purl"
+		switch node.attr("type") {
+		case "back":
+			if purl {
+				return []ParsedExpr{&ParsedPurlCableRC{FrontCount: front, BackCount: back}}, nil
+			}
+			return []ParsedExpr{&ParsedCableRC{FrontCount: front, BackCount: back}}, nil
+		case "front":
+			if purl {
+				return []ParsedExpr{&ParsedPurlCableLC{FrontCount: front, BackCount: back}}, nil
+			}
+			return []ParsedExpr{&ParsedCableLC{FrontCount: front, BackCount: back}}, nil
+		}
+		return nil, fmt.Errorf("unsupported cross-stitches type %q", node.attr("type"))
+	case "place-marker":
+		return []ParsedExpr{&PlaceMarker{Name: node.attr("label")}}, nil
+	case "remove-marker":
+		return []ParsedExpr{&RemoveMarker{Name: node.attr("label")}}, nil
+	case "repeat":
+		group := &ParsedGroup{}
+		for _, child := range node.Nodes {
+			exprs, err := knitmlReadExpr(child)
+			if err != nil {
+				return nil, err
+			}
+			group.Content = append(group.Content, exprs...)
+		}
+		value, err := node.intAttr("value", 0)
+		if err != nil {
+			return nil, err
+		}
+		switch node.attr("until") {
+		case "end":
+			return []ParsedExpr{&ParsedRepeatExact{Content: group, Count: 0}}, nil
+		case "times":
+			return []ParsedExpr{&ParsedRepeatExact{Content: group, Count: value}}, nil
+		case "before-end":
+			return []ParsedExpr{&ParsedRepeatNeg{Content: group, Count: value}}, nil
+		}
+		return nil, fmt.Errorf("unsupported repeat until=%q", node.attr("until"))
+	default:
+		return nil, fmt.Errorf("unsupported element <%s>", name)
+	}
+}
+
+// knitmlRoundTrip exporta el patrón a KnitML, lo vuelve a importar y comprueba
+// que se tejen los mismos puntos.
+func knitmlRoundTrip(pattern *CompiledPattern) error {
+	data, err := knitmlExport(pattern)
+	if err != nil {
+		return err
+	}
+	again, err := knitmlImport(pattern.Name, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("re-importing KnitML: %v", err)
+	}
+	return sameStitches(pattern.Compiler.Rows, again.Compiler.Rows)
+}
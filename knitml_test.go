@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Cada patrón de patterns/ se exporta a KnitML y se vuelve a importar; tiene
+// que tejer los mismos puntos.
+func TestKnitmlRoundTripPatterns(t *testing.T) {
+	files, err := filepath.Glob("patterns/*.knit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no patterns in patterns/")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			pattern, err := loadPattern(file)
+			if err != nil {
+				t.Fatalf("compiling: %v", err)
+			}
+			if err := knitmlRoundTrip(pattern); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Las filas shaping siguen siéndolo después de pasar por KnitML.
+func TestKnitmlShaping(t *testing.T) {
+	src := "section a {\nco6;\nshaping k2tog k4;\np*0;\n}\n"
+	pattern, err := compilePattern("shaping", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := knitmlRoundTrip(pattern); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Calado a máquina con carro de transferencia. Antes de tejer cada fila el
+// carro pasa los puntos a la aguja de al lado: una reducción es un punto que
+// se pasa encima de su vecino y un yo es la aguja que queda vacía. Qué punto
+// se pasa da la inclinación: en k2tog (y p2tog) el de la izquierda sobre el
+// de la derecha, en ssk el de la derecha sobre el de la izquierda. Cada pasada
+// del carro mueve los puntos una sola aguja, así que si un punto tiene que
+// viajar más lejos (k3tog, varias reducciones seguidas sin su yo, un ssk con
+// el yo a su izquierda) hacen falta varias pasadas.
+//
+// Las agujas se numeran desde 1 de izquierda a derecha, como se ve el gráfico
+// por el derecho.
+
+// LaceTransfer mueve el punto de la aguja From a la aguja To. Pass es la
+// pasada del carro en la que llega: un punto que ya se movió en una
+// transferencia anterior de la misma reducción no puede seguir hasta la
+// pasada siguiente.
+type LaceTransfer struct {
+	From, To int
+	Pass     int
+}
+
+func (t LaceTransfer) distance() int {
+	if t.To > t.From {
+		return t.To - t.From
+	}
+	return t.From - t.To
+}
+
+// LaceRow es el mapa de transferencias de una fila.
+type LaceRow struct {
+	Row       PatternRow
+	Needles   int // agujas con punto antes de transferir
+	Transfers []LaceTransfer
+	Empty     []int // agujas vacías tras transferir, donde van los yo
+	Passes    int   // pasadas del carro necesarias
+}
+
+// laceTransfers calcula el mapa de cada fila. Cada punto de la fila termina en
+// la aguja de su posición en la fila tejida. En una reducción los puntos que
+// van encima se pasan a la aguja del que queda debajo y, si esa no es la de
+// su posición, el punto ya reducido se lleva después hasta ella.
+func laceTransfers(rows []*Row) ([]LaceRow, error) {
+	var out []LaceRow
+	for _, pr := range numberRows(rows) {
+		if pr.CastOn {
+			continue
+		}
+		sts := pr.Row.Stitches
+		if pr.RS {
+			sts = reverse(slices.Clone(sts))
+		}
+
+		lr := LaceRow{Row: pr, Needles: pr.Row.advance()}
+		from, to := 1, 1
+		for i, st := range sts {
+			switch st.(type) {
+			case *Knit, *Purl, *Ktog, *Ptog, *Ssk:
+				// base es la aguja del punto que queda debajo.
+				base := from + st.advance() - 1
+				if _, ok := st.(*Ssk); ok {
+					base = from
+				}
+				pass := 0
+				for n := from; n < from+st.advance(); n++ {
+					if n != base {
+						t := LaceTransfer{From: n, To: base}
+						t.Pass = t.distance()
+						pass = max(pass, t.Pass)
+						lr.Transfers = append(lr.Transfers, t)
+					}
+				}
+				if base != to {
+					t := LaceTransfer{From: base, To: to}
+					t.Pass = pass + t.distance()
+					lr.Transfers = append(lr.Transfers, t)
+				}
+			case *Yo:
+				lr.Empty = append(lr.Empty, to)
+			default:
+				// i cuenta desde la izquierda; en el derecho se teje al revés.
+				n := i + 1
+				if pr.RS {
+					n = len(sts) - i
+				}
+				return nil, fmt.Errorf("row %d (%s), st %d: %s cannot be worked with the lace carriage",
+					pr.Index, side(pr.RS), n, st)
+			}
+			from += st.advance()
+			to += st.weight()
+		}
+		for _, t := range lr.Transfers {
+			lr.Passes = max(lr.Passes, t.Pass)
+		}
+		out = append(out, lr)
+	}
+	return out, nil
+}
+
+// transferMap dibuja una línea con un carácter por aguja: "." se queda, "<" y
+// ">" pasan a la aguja de al lado y "«" y "»" van más lejos.
+func (lr LaceRow) transferMap() string {
+	needles := make([]rune, lr.Needles)
+	for i := range needles {
+		needles[i] = '.'
+	}
+	for _, t := range lr.Transfers {
+		switch {
+		case t.To == t.From-1:
+			needles[t.From-1] = '<'
+		case t.To < t.From:
+			needles[t.From-1] = '«'
+		case t.To == t.From+1:
+			needles[t.From-1] = '>'
+		default:
+			needles[t.From-1] = '»'
+		}
+	}
+	return string(needles)
+}
+
+// laceReport escribe el mapa de transferencias de todas las filas y avisa de
+// las que necesitan más de una pasada del carro.
+func laceReport(rows []*Row) (string, error) {
+	laceRows, err := laceTransfers(rows)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, lr := range laceRows {
+		label := fmt.Sprintf("Row %d (%s)", lr.Row.Index, side(lr.Row.RS))
+		if len(lr.Transfers) == 0 && len(lr.Empty) == 0 {
+			fmt.Fprintf(&b, "%-12s knit\n", label)
+			continue
+		}
+		fmt.Fprintf(&b, "%-12s %s", label, lr.transferMap())
+		if len(lr.Empty) > 0 {
+			fmt.Fprintf(&b, "  empty: %s", joinInts(lr.Empty))
+		}
+		b.WriteByte('\n')
+		if lr.Passes > 1 {
+			var late []string
+			for _, t := range lr.Transfers {
+				if t.Pass > 1 {
+					late = append(late, fmt.Sprintf("%d->%d in pass %d", t.From, t.To, t.Pass))
+				}
+			}
+			fmt.Fprintf(&b, "%-12s warning: decreases need %d carriage passes (%s)\n",
+				"", lr.Passes, strings.Join(late, ", "))
+		}
+	}
+	return b.String(), nil
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// k2tog y ssk juntan los mismos dos puntos, pero cada uno pasa el contrario
+// encima del otro.
+func TestLaceTransfersLean(t *testing.T) {
+	lace := func(row string) LaceRow {
+		t.Helper()
+		src := "section a {\nco6;\n" + row + ";\n}\n"
+		pattern, err := compilePattern("lace", strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := laceTransfers(pattern.Compiler.Rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rows[0]
+	}
+
+	k2tog, ssk := lace("k2 k2tog yo k2"), lace("k2 ssk yo k2")
+	if want := []LaceTransfer{{From: 3, To: 4, Pass: 1}}; !slices.Equal(k2tog.Transfers, want) {
+		t.Errorf("k2tog transfers = %v, want %v", k2tog.Transfers, want)
+	}
+	if want := []LaceTransfer{{From: 4, To: 3, Pass: 1}, {From: 3, To: 4, Pass: 2}}; !slices.Equal(ssk.Transfers, want) {
+		t.Errorf("ssk transfers = %v, want %v", ssk.Transfers, want)
+	}
+	if k2tog.transferMap() == ssk.transferMap() {
+		t.Errorf("k2tog and ssk give the same map %q", k2tog.transferMap())
+	}
+
+	// Con el yo al otro lado es el ssk el que sale en una pasada.
+	if got, want := lace("k2 yo ssk k2").Transfers, []LaceTransfer{{From: 4, To: 3, Pass: 1}}; !slices.Equal(got, want) {
+		t.Errorf("yo ssk transfers = %v, want %v", got, want)
+	}
+}
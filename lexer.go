@@ -48,6 +48,10 @@ const (
 	REMOVEMARKER
 	NEG
 	COMMENT
+
+	META
+	STRING
+	SHAPING
 )
 
 var tokens = []string{
@@ -87,6 +91,9 @@ var tokens = []string{
 	NEG:		"NEG",
 	REPBLOCK:	"REPBLOCK",
 	COMMENT: 	"COMMENT",
+	META:		"META",
+	STRING:		"STRING",
+	SHAPING:	"SHAPING",
 }
 
 func (t Token) String() string{
@@ -106,6 +113,15 @@ type Position struct {
 	column int
 }
 
+func (p Position) Line() int   { return p.line }
+func (p Position) Column() int { return p.column }
+
+// Span es el tramo del código fuente que ocupa una fila, del primer token al ';'.
+type Span struct {
+	Start Position
+	End   Position
+}
+
 type Lexer struct {
 	pos Position
 	reader *bufio.Reader
@@ -132,7 +148,6 @@ func (l *Lexer) Lex() (Position, Token, string) {
 
 		switch r {
 		case ';':
-			l.resetPosition()
 			return l.pos, SEMICOLON, ";"
 		case '-':
 			return l.pos, NEG, "-"
@@ -146,6 +161,9 @@ func (l *Lexer) Lex() (Position, Token, string) {
 			return l.pos, PARCLOSE, ")"
 		case '*':
 			return l.pos, REP, "*"
+		case '"':
+			startPos := l.pos
+			return startPos, STRING, l.lexString()
 		case '/':
 			next, _, err := l.reader.ReadRune()
 			if err == nil {
@@ -158,6 +176,9 @@ func (l *Lexer) Lex() (Position, Token, string) {
 			}
 		default:
 			if unicode.IsSpace(r){
+				if r == '\n' {
+					l.resetPosition()
+				}
 				continue
 			} else if unicode.IsDigit(r) {
 				startPos := l.pos
@@ -183,6 +204,10 @@ func (l *Lexer) Lex() (Position, Token, string) {
 					return startPos, REPBLOCK, "REPBLOCK"
 				case lit == "section":
 					return startPos, SECTION, "SECTION"
+				case lit == "meta":
+					return startPos, META, "META"
+				case lit == "shaping":
+					return startPos, SHAPING, "SHAPING"
 				case isKtog(lit):
 					return startPos, KTOG, l.lexKtog(lit)
 				case isPtog(lit):
@@ -244,6 +269,20 @@ func (l *Lexer) lexComment() string {
     return lit
 }
 
+// Lee una cadena entre comillas; la comilla de apertura ya se ha consumido.
+func (l *Lexer) lexString() string {
+	var lit string
+	for {
+		r, _, err := l.reader.ReadRune()
+		if err != nil || r == '"' {
+			l.pos.column++
+			return lit
+		}
+		l.pos.column++
+		lit += string(r)
+	}
+}
+
 func (l *Lexer) lexInt() string{
 	var lit string
 	for {
@@ -297,7 +336,7 @@ func (l *Lexer) lexBo(lit string) string {
 }
 
 func (l *Lexer) lexRepeatCount(lit string) string {
-	return string(lit[1])
+	return lit[1:]
 }
 
 func (l *Lexer) lexMarkerName(lit string) string{
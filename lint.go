@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Linter de patrones .knit. Cada regla se puede desactivar o cambiar de
+// severidad con un archivo JSON:
+//
+//	{
+//	  "rules": {
+//	    "cable-width": {"max": 3},
+//	    "mixed-repeat-syntax": {"disabled": true},
+//	    "purl-rs-row": {"severity": "error"}
+//	  }
+//	}
+//
+// Si no se indica, se busca lintConfigName junto al patrón.
+
+const lintConfigName = "goknit-lint.json"
+
+// RuleConfig configura una regla. Max solo lo usan las reglas con límite.
+type RuleConfig struct {
+	Disabled bool   `json:"disabled"`
+	Severity string `json:"severity"` // "error", "warning" o "info"
+	Max      int    `json:"max"`
+}
+
+type LintConfig struct {
+	Rules map[string]RuleConfig `json:"rules"`
+}
+
+// lintRule comprueba una cosa del patrón. src es el código fuente, que hace
+// falta para las reglas de sintaxis.
+type lintRule struct {
+	name     string
+	defaults RuleConfig
+	check    func(l *linter, src []byte, pattern *CompiledPattern)
+}
+
+var lintRules = []lintRule{
+	{"cable-width", RuleConfig{Severity: "warning", Max: 4}, lintCableWidth},
+	{"purl-rs-row", RuleConfig{Severity: "warning"}, lintPurlRSRow},
+	{"mixed-repeat-syntax", RuleConfig{Severity: "info"}, lintMixedRepeatSyntax},
+	{"fill-before-stitches", RuleConfig{Severity: "warning"}, lintFillBeforeStitches},
+	{"section-without-co", RuleConfig{Severity: "error"}, lintSectionWithoutCo},
+}
+
+// LintIssue es un aviso del linter en una posición del código.
+type LintIssue struct {
+	Rule     string
+	Severity string
+	Pos      Position
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s [%s]", i.Pos.Line(), i.Pos.Column(), i.Severity, i.Message, i.Rule)
+}
+
+type linter struct {
+	rule   string
+	config RuleConfig
+	issues []LintIssue
+}
+
+func (l *linter) report(pos Position, format string, args ...any) {
+	l.issues = append(l.issues, LintIssue{
+		Rule:     l.rule,
+		Severity: l.config.Severity,
+		Pos:      pos,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// loadLintConfig lee la configuración de path. Con path vacío busca
+// lintConfigName en dir y, si no está, usa la configuración por defecto.
+func loadLintConfig(path, dir string) (LintConfig, error) {
+	config := LintConfig{Rules: map[string]RuleConfig{}}
+	if path == "" {
+		path = filepath.Join(dir, lintConfigName)
+		if _, err := os.Stat(path); err != nil {
+			return config, nil
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", path, err)
+	}
+	known := map[string]bool{}
+	for _, rule := range lintRules {
+		known[rule.name] = true
+	}
+	for name, rule := range config.Rules {
+		if !known[name] {
+			return config, fmt.Errorf("%s: unknown rule %q", path, name)
+		}
+		switch rule.Severity {
+		case "", "error", "warning", "info":
+		default:
+			return config, fmt.Errorf("%s: rule %q: unknown severity %q", path, name, rule.Severity)
+		}
+	}
+	return config, nil
+}
+
+// lintPattern pasa todas las reglas activas y devuelve los avisos ordenados
+// por posición.
+func lintPattern(name string, src []byte, config LintConfig) ([]LintIssue, error) {
+	pattern, err := compilePattern(name, bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	var issues []LintIssue
+	for _, rule := range lintRules {
+		cfg := rule.defaults
+		if custom, ok := config.Rules[rule.name]; ok {
+			cfg.Disabled = custom.Disabled
+			if custom.Severity != "" {
+				cfg.Severity = custom.Severity
+			}
+			if custom.Max != 0 {
+				cfg.Max = custom.Max
+			}
+		}
+		if cfg.Disabled {
+			continue
+		}
+		l := &linter{rule: rule.name, config: cfg}
+		rule.check(l, src, pattern)
+		issues = append(issues, l.issues...)
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i].Pos, issues[j].Pos
+		if a.Line() != b.Line() {
+			return a.Line() < b.Line()
+		}
+		return a.Column() < b.Column()
+	})
+	return issues, nil
+}
+
+// parsedRows devuelve las filas parseadas de una sección, con las de los
+// bloques "repeat" una sola vez.
+func parsedRows(section *Section) []*ParsedRow {
+	var rows []*ParsedRow
+	for _, node := range section.Content {
+		switch n := node.(type) {
+		case *ParsedRow:
+			rows = append(rows, n)
+		case *ParsedRepeatBlock:
+			rows = append(rows, n.Content...)
+		}
+	}
+	return rows
+}
+
+// walkExprs llama a fn con cada expresión de exprs y con las que contiene.
+func walkExprs(exprs []ParsedExpr, fn func(ParsedExpr)) {
+	for _, expr := range exprs {
+		fn(expr)
+		switch e := expr.(type) {
+		case *ParsedGroup:
+			walkExprs(e.Content, fn)
+		case *ParsedRepeatExact:
+			walkExprs([]ParsedExpr{e.Content}, fn)
+		case *ParsedRepeatNeg:
+			walkExprs([]ParsedExpr{e.Content}, fn)
+		}
+	}
+}
+
+// Los cables de más de Max puntos delante son difíciles de cruzar y tiran de
+// la labor.
+func lintCableWidth(l *linter, src []byte, pattern *CompiledPattern) {
+	for _, section := range pattern.Sections {
+		for _, row := range parsedRows(section) {
+			walkExprs(row.Content, func(expr ParsedExpr) {
+				front := 0
+				switch c := expr.(type) {
+				case *ParsedCableRC:
+					front = c.FrontCount
+				case *ParsedCableLC:
+					front = c.FrontCount
+				case *ParsedPurlCableRC:
+					front = c.FrontCount
+				case *ParsedPurlCableLC:
+					front = c.FrontCount
+				}
+				if front > l.config.Max {
+					l.report(row.Span.Start, "%s crosses %d sts in front (more than %d)", exprSource(expr), front, l.config.Max)
+				}
+			})
+		}
+	}
+}
+
+// En una pieza en plano las filas del derecho casi nunca son todo revés; suele
+// ser que la fila está del lado equivocado. En redondo (meta construction
+// "round") todas las filas son del derecho y la regla no se aplica.
+func lintPurlRSRow(l *linter, src []byte, pattern *CompiledPattern) {
+	if strings.EqualFold(pattern.Meta["construction"], "round") {
+		return
+	}
+	for _, section := range pattern.Sections {
+		seen := map[Span]bool{}
+		for _, pr := range pattern.PatternRows(section.Name) {
+			if pr.CastOn || !pr.RS || len(pr.Row.Stitches) == 0 || seen[pr.Row.Span] {
+				continue
+			}
+			allPurl := true
+			for _, st := range pr.Row.Stitches {
+				if _, ok := st.(*Purl); !ok {
+					allPurl = false
+					break
+				}
+			}
+			if allPurl {
+				seen[pr.Row.Span] = true
+				l.report(pr.Row.Span.Start, "row %d is a RS row worked all in purl; check the row side", pr.Index)
+			}
+		}
+	}
+}
+
+// k4 y k*4 significan lo mismo; se avisa una vez, en el primer uso de la
+// forma menos usada del archivo.
+func lintMixedRepeatSyntax(l *linter, src []byte, pattern *CompiledPattern) {
+	var short, star []Position
+	lexer := NewLexer(bytes.NewReader(src))
+	var prev [2]Token
+	var prevPos Position
+	for {
+		pos, tok, lit := lexer.Lex()
+		if tok == EOF {
+			break
+		}
+		switch {
+		case tok == KNIT_REPEAT || tok == PURL_REPEAT:
+			short = append(short, pos)
+		case tok == INT && lit != "0" && prev[1] == REP && (prev[0] == KNIT || prev[0] == PURL):
+			star = append(star, prevPos)
+		}
+		if tok == KNIT || tok == PURL {
+			prevPos = pos
+		}
+		prev[0], prev[1] = prev[1], tok
+	}
+	if len(short) == 0 || len(star) == 0 {
+		return
+	}
+	if len(star) < len(short) {
+		l.report(star[0], "k*4 syntax used %d times and k4 %d times; use one form in the whole pattern", len(star), len(short))
+	} else {
+		l.report(short[0], "k4 syntax used %d times and k*4 %d times; use one form in the whole pattern", len(short), len(star))
+	}
+}
+
+// Un *0 seguido de más puntos en la fila casi siempre debía ser *-N: el *0
+// usa todos los puntos que quedan y los del final solo caben si sobran por
+// casualidad.
+func lintFillBeforeStitches(l *linter, src []byte, pattern *CompiledPattern) {
+	c := NewCompiler()
+	for _, section := range pattern.Sections {
+		for _, row := range parsedRows(section) {
+			for i, expr := range row.Content {
+				fill, ok := expr.(*ParsedRepeatExact)
+				if !ok || fill.Count != 0 {
+					continue
+				}
+				rest := 0
+				for _, after := range row.Content[i+1:] {
+					if compiled, err := c.compileExpr(after); err == nil {
+						rest += c.exprAdvance(compiled)
+					}
+				}
+				if rest > 0 {
+					l.report(row.Span.Start, "%s is followed by %d sts; did you mean %s*-%d?",
+						exprSource(fill), rest, exprSource(fill.Content), rest)
+				}
+			}
+		}
+	}
+}
+
+// Una sección sin puntos vivos de las anteriores (la primera, o después de
+// cerrar todos los puntos) tiene que montar los suyos.
+func lintSectionWithoutCo(l *linter, src []byte, pattern *CompiledPattern) {
+	live := 0
+	for _, section := range pattern.Sections {
+		rows := parsedRows(section)
+		if live == 0 && !hasCo(rows) {
+			pos := Position{line: 1}
+			if len(rows) > 0 {
+				pos = rows[0].Span.Start
+			}
+			l.report(pos, "section %s has no co and there are no stitches before it", section.Name)
+		}
+		if compiled := pattern.SectionRows(section.Name); len(compiled) > 0 {
+			live = compiled[len(compiled)-1].weight()
+		}
+	}
+}
+
+func hasCo(rows []*ParsedRow) bool {
+	for _, row := range rows {
+		for _, expr := range row.Content {
+			if _, ok := expr.(*ParsedCo); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
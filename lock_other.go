@@ -0,0 +1,24 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile crea path en exclusiva y lo borra al soltar el cerrojo. Si el
+// programa muere el archivo se queda y hay que borrarlo a mano.
+func lockFile(path string) (func() error, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, errSessionLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return func() error {
+		return os.Remove(path)
+	}, nil
+}
@@ -88,6 +88,10 @@ func launch_parser(){
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		exitOnError(runCommand(os.Args[1:]))
+		return
+	}
 	// readSession("lib/example.json")
 	// app()
 	launch_parser()
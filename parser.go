@@ -168,6 +168,8 @@ func (r *RemoveMarker) String() string{ return "Remove Marker "+r.Name }
 
 type ParsedRow struct {
 	Content []ParsedExpr
+	Span    Span
+	Shaping bool // "shaping k2tog k*0;": la fila cambia la cuenta a propósito
 }
 func (r *ParsedRow) String() string {
 	var exprs []string
@@ -186,6 +188,7 @@ type Section struct {
 
 type Parser struct {
 	l *Lexer  
+	Meta map[string]string // Cabecera "meta { clave "valor"; }" del patrón
 	buf struct {
 		pos Position
 		n int		// 0 si no hay guardado, 1 si hay. i{}
@@ -196,7 +199,7 @@ type Parser struct {
 
 // NewParser constructor
 func NewParser(f io.Reader) *Parser{
-	return &Parser {l: NewLexer(f)}
+	return &Parser {l: NewLexer(f), Meta: map[string]string{}}
 }
 
 func (p *Parser) scan() (Position, Token, string) {
@@ -214,6 +217,12 @@ func (p *Parser) scan() (Position, Token, string) {
 	return pos, tok, lit
 }
 
+// position es la posición del último token leído; tras un error, más o menos
+// donde está el fallo.
+func (p *Parser) position() Position {
+	return p.buf.pos
+}
+
 func (p *Parser) unscan()  {
 	if p.buf.n != 0 {
 		panic("unscan called twice without scan")
@@ -473,9 +482,19 @@ func (p *Parser) parseParsedRepeat(content ParsedExpr) (ParsedExpr, error) {
 
 func (p *Parser) parseRow() (*ParsedRow, error){
 	var exprs []ParsedExpr
+	var span Span
+	shaping := false
 	for {
-		_, tok, _ := p.scan()
+		pos, tok, _ := p.scan()
+		if len(exprs) == 0 && !shaping {
+			span.Start = pos
+			if tok == SHAPING {
+				shaping = true
+				continue
+			}
+		}
 		if tok == SEMICOLON {
+			span.End = pos
 			break
 		}
 		if tok == EOF || tok == BRCLOSE {
@@ -491,7 +510,7 @@ func (p *Parser) parseRow() (*ParsedRow, error){
 			panic("empty row")
 		}
 	}
-	return &ParsedRow{Content: exprs}, nil
+	return &ParsedRow{Content: exprs, Span: span, Shaping: shaping}, nil
 }
 
 func (p *Parser) parseParsedRepeatBlock() (*ParsedRepeatBlock, error){
@@ -524,7 +543,7 @@ func (p *Parser) parseParsedRepeatBlock() (*ParsedRepeatBlock, error){
 		p.unscan()
 		row, err := p.parseRow()
 		if err != nil {
-			return nil, fmt.Errorf("error parsing row %q at %v: %v", pos, err)
+			return nil, fmt.Errorf("error parsing row at %v: %v", pos, err)
 		}
 		rows =  append(rows, row)
 	}
@@ -595,6 +614,36 @@ func (p *Parser) parseSection() (*Section, error) {
 	return section, nil
 }
 
+// parseMeta lee la cabecera de metadatos: meta { title "Lace"; rows 12; }
+func (p *Parser) parseMeta() error {
+	pos, tok, _ := p.scan()
+	if tok != META {
+		return fmt.Errorf("expected 'meta', got %q at %v", tok, pos)
+	}
+	pos, tok, _ = p.scan()
+	if tok != BROPEN {
+		return fmt.Errorf("expected '{', got %q at %v", tok, pos)
+	}
+	for {
+		pos, tok, key := p.scan()
+		if tok == BRCLOSE {
+			return nil
+		}
+		if tok != IDENT {
+			return fmt.Errorf("expected meta key (IDENT), got %q at %v", tok, pos)
+		}
+		pos, tok, value := p.scan()
+		if tok != STRING && tok != INT {
+			return fmt.Errorf("expected value for %q, got %q at %v", key, tok, pos)
+		}
+		pos, tok, _ = p.scan()
+		if tok != SEMICOLON {
+			return fmt.Errorf("expected ';' after %q, got %q at %v", key, tok, pos)
+		}
+		p.Meta[key] = value
+	}
+}
+
 func (p *Parser) ParsePattern() ([]*Section, error) {
 	var sections []*Section
 
@@ -604,6 +653,14 @@ func (p *Parser) ParsePattern() ([]*Section, error) {
 			break
 		}
 
+		if tok == META {
+			p.unscan()
+			if err := p.parseMeta(); err != nil {
+				return nil, fmt.Errorf("error parsing meta at %v: %v", pos, err)
+			}
+			continue
+		}
+
 		p.unscan()
 		section, err := p.parseSection()
 		if err != nil {
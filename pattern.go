@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CompiledPattern reúne la cabecera, las secciones parseadas y las filas
+// compiladas de un archivo .knit.
+type CompiledPattern struct {
+	Name     string
+	Meta     map[string]string
+	Sections []*Section
+	Compiler *Compiler
+}
+
+func loadPattern(path string) (*CompiledPattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return compilePattern(name, file)
+}
+
+func compilePattern(name string, r io.Reader) (*CompiledPattern, error) {
+	parser := NewParser(r)
+	sections, err := parser.ParsePattern()
+	if err != nil {
+		return nil, &CompileError{Pos: parser.position(), Err: err}
+	}
+
+	c := NewCompiler()
+	for _, section := range sections {
+		if err := c.compileSection(section); err != nil {
+			return nil, err
+		}
+	}
+	return &CompiledPattern{
+		Name:     name,
+		Meta:     parser.Meta,
+		Sections: sections,
+		Compiler: c,
+	}, nil
+}
+
+// Title devuelve el título de la cabecera o, si no hay, el nombre del archivo.
+func (p *CompiledPattern) Title() string {
+	if title, ok := p.Meta["title"]; ok {
+		return title
+	}
+	return p.Name
+}
+
+// SectionRows devuelve las filas compiladas de la sección name.
+func (p *CompiledPattern) SectionRows(name string) []*Row {
+	var rows []*Row
+	for _, row := range p.Compiler.Rows {
+		if row.Section == name {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+func (p *CompiledPattern) String() string {
+	return fmt.Sprintf("%s (%d sections, %d rows)", p.Title(), len(p.Sections), len(p.Compiler.Rows))
+}
+
+// PatternRow es una fila compilada con la numeración de un patrón escrito: el
+// montaje no cuenta como fila, la fila 1 es la primera tras el montaje y las
+// filas impares son del derecho (RS), igual que en el gráfico.
+type PatternRow struct {
+	Row    *Row
+	Index  int
+	RS     bool
+	CastOn bool
+}
+
+// PatternRows numera las filas de una sección como en un patrón escrito.
+func (p *CompiledPattern) PatternRows(section string) []PatternRow {
+	return numberRows(p.SectionRows(section))
+}
+
+func numberRows(rows []*Row) []PatternRow {
+	var out []PatternRow
+	n := 0
+	for _, row := range rows {
+		if isCastOnRow(row) {
+			out = append(out, PatternRow{Row: row, CastOn: true})
+			continue
+		}
+		n++
+		out = append(out, PatternRow{Row: row, Index: n, RS: n%2 == 1})
+	}
+	return out
+}
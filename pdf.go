@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// Escritor mínimo de PDF 1.4: páginas con texto en Helvetica (fuente estándar,
+// no hace falta incrustarla), rectángulos e imágenes RGB comprimidas.
+
+// Tamaño A4 en puntos.
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+)
+
+type pdfDocument struct {
+	pages  []*pdfPage
+	images []image.Image
+}
+
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func newPDF() *pdfDocument {
+	return &pdfDocument{}
+}
+
+func (d *pdfDocument) addPage() *pdfPage {
+	page := &pdfPage{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// text escribe s con su línea base en (x, y); el origen está abajo a la izquierda.
+func (p *pdfPage) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+// rect dibuja un rectángulo relleno con el gris dado (0 negro, 1 blanco).
+func (p *pdfPage) rect(x, y, w, h, gray float64) {
+	fmt.Fprintf(&p.content, "q %.3f g %.2f %.2f %.2f %.2f re f Q\n", gray, x, y, w, h)
+}
+
+// line dibuja una línea negra de grosor width.
+func (p *pdfPage) line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "q %.2f w %.2f %.2f m %.2f %.2f l S Q\n", width, x1, y1, x2, y2)
+}
+
+// image coloca img con su esquina inferior izquierda en (x, y).
+func (p *pdfPage) image(d *pdfDocument, img image.Image, x, y, w, h float64) {
+	d.images = append(d.images, img)
+	fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, y, len(d.images))
+}
+
+func (d *pdfDocument) write(w io.Writer) error {
+	var out bytes.Buffer
+	var offsets []int
+
+	// Los objetos se numeran en este orden: catálogo, árbol de páginas,
+	// fuentes, imágenes y, para cada página, la página y su contenido.
+	const (
+		catalogObj = 1
+		pagesObj   = 2
+		fontObj    = 3
+		boldObj    = 4
+		firstImage = 5
+	)
+	firstPage := firstImage + len(d.images)
+
+	object := func(body []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n", len(offsets))
+		out.Write(body)
+		out.WriteString("\nendobj\n")
+	}
+	stream := func(dict string, data []byte) []byte {
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "<< %s /Length %d >>\nstream\n", dict, len(data))
+		b.Write(data)
+		b.WriteString("\nendstream")
+		return b.Bytes()
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	object(fmt.Appendf(nil, "<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	object(fmt.Appendf(nil, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	object([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"))
+	object([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>"))
+
+	var xobjects []string
+	for i, img := range d.images {
+		data, err := pdfImageData(img)
+		if err != nil {
+			return err
+		}
+		b := img.Bounds()
+		dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode", b.Dx(), b.Dy())
+		object(stream(dict, data))
+		xobjects = append(xobjects, fmt.Sprintf("/Im%d %d 0 R", i+1, firstImage+i))
+	}
+
+	resources := fmt.Sprintf("<< /Font << /F1 %d 0 R /F2 %d 0 R >> /XObject << %s >> >>", fontObj, boldObj, strings.Join(xobjects, " "))
+	for i, page := range d.pages {
+		object(fmt.Appendf(nil, "<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R >>",
+			pagesObj, pdfPageWidth, pdfPageHeight, resources, firstPage+2*i+1))
+		object(stream("", page.content.Bytes()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, catalogObj, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// pdfImageData devuelve los píxeles RGB de img comprimidos con zlib.
+func pdfImageData(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	b := img.Bounds()
+	row := make([]byte, 0, 3*b.Dx())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row = row[:0]
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			row = append(row, byte(r>>8), byte(g>>8), byte(bl>>8))
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pdfEscape pasa s a WinAnsi y escapa los paréntesis y las barras.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x80:
+			b.WriteByte(byte(r))
+		case r >= 0xa0 && r <= 0xff:
+			// Latin-1 coincide con WinAnsi en este rango (á, é, ñ...).
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Anchos de Helvetica (en milésimas de em) para los caracteres 32-126.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// pdfTextWidth calcula el ancho aproximado de s en puntos. La negrita se
+// aproxima con un 5% más de ancho.
+func pdfTextWidth(s string, size float64, bold bool) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	w := float64(total) * size / 1000
+	if bold {
+		w *= 1.05
+	}
+	return w
+}
+
+// pdfWrap parte s en líneas que caben en width puntos, cortando por espacios.
+func pdfWrap(s string, size, width float64, bold bool) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line != "" && pdfTextWidth(candidate, size, bold) > width {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line = candidate
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
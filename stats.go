@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Estadísticas de un patrón compilado: filas, puntos tejidos, cuentas de
+// puntos y las filas que aumentan o menguan.
+
+// RowDelta es el cambio de puntos de una fila: los que tenía la aguja antes
+// (Before) y los que quedan después (After).
+type RowDelta struct {
+	Row           PatternRow
+	Before, After int
+}
+
+func (d RowDelta) delta() int {
+	return d.After - d.Before
+}
+
+// SectionStats resume una sección.
+type SectionStats struct {
+	Name    string
+	Rows    int // sin contar el montaje
+	Worked  int // puntos tejidos
+	MinSts  int
+	MaxSts  int
+	ByType  map[string]int
+	Deltas  []RowDelta // una por fila tejida
+	Shaping []RowDelta // solo las que cambian la cuenta
+}
+
+// stitchKind es el nombre con el que se cuenta un punto: su código .knit.
+func stitchKind(st Stitch) string {
+	if src, err := stitchSource(st); err == nil {
+		// co27 y bo3 se cuentan juntos.
+		if _, ok := st.(*Co); ok {
+			return "co"
+		}
+		if _, ok := st.(*Bo); ok {
+			return "bo"
+		}
+		return src
+	}
+	return st.String()
+}
+
+func patternStats(pattern *CompiledPattern) []SectionStats {
+	var out []SectionStats
+	for _, section := range pattern.Sections {
+		s := SectionStats{Name: section.Name, ByType: map[string]int{}}
+		first := true
+		for _, pr := range pattern.PatternRows(section.Name) {
+			sts := pr.Row.weight()
+			if first || sts < s.MinSts {
+				s.MinSts = sts
+			}
+			s.MaxSts = max(s.MaxSts, sts)
+			first = false
+
+			for _, st := range pr.Row.Stitches {
+				s.ByType[stitchKind(st)]++
+			}
+			if pr.CastOn {
+				continue
+			}
+			s.Rows++
+			s.Worked += len(pr.Row.Stitches)
+			d := RowDelta{Row: pr, Before: pr.Row.advance(), After: sts}
+			s.Deltas = append(s.Deltas, d)
+			if d.delta() != 0 {
+				s.Shaping = append(s.Shaping, d)
+			}
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func (s SectionStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", s.Name)
+	fmt.Fprintf(&b, "  rows:           %d\n", s.Rows)
+	fmt.Fprintf(&b, "  sts worked:     %d\n", s.Worked)
+	fmt.Fprintf(&b, "  sts on needle:  %d to %d\n", s.MinSts, s.MaxSts)
+
+	var kinds []string
+	for kind := range s.ByType {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if s.ByType[kinds[i]] != s.ByType[kinds[j]] {
+			return s.ByType[kinds[i]] > s.ByType[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	b.WriteString("  stitches:      ")
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, " %s %d", kind, s.ByType[kind])
+	}
+	b.WriteByte('\n')
+
+	b.WriteString("  rows (inc/dec):\n")
+	for _, d := range s.Deltas {
+		flag := ""
+		if d.delta() != 0 {
+			flag = "  <- changes stitch count"
+		}
+		inc, dec := rowShaping(d.Row.Row)
+		fmt.Fprintf(&b, "    row %3d (%s) %3d -> %3d sts  +%d -%d  %+d%s\n",
+			d.Row.Index, side(d.Row.RS), d.Before, d.After, inc, dec, d.delta(), flag)
+	}
+	if len(s.Shaping) == 0 {
+		b.WriteString("  stitch count does not change\n")
+	}
+	return b.String()
+}
+
+// rowShaping cuenta los puntos que añade (yo, montajes) y los que quita
+// (reducciones, cierres) una fila.
+func rowShaping(row *Row) (inc, dec int) {
+	for _, st := range row.Stitches {
+		if d := st.weight() - st.advance(); d > 0 {
+			inc += d
+		} else {
+			dec -= d
+		}
+	}
+	return inc, dec
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Almacenamiento de sesiones y patrones de la TUI. La TUI solo usa
+// SessionStore; fileStore guarda en disco y memoryStore en memoria.
+
+// SessionStore guarda las sesiones (archivos .json) y los patrones (.knit) por
+// nombre de archivo.
+type SessionStore interface {
+	ListSessions() ([]string, error)
+	LoadSession(file string) (Session, error)
+	SaveSession(file string, session Session) error
+	ListPatterns() ([]string, error)
+	ReadPattern(file string) ([]byte, error)
+	WritePattern(file string, data []byte) error
+	// El diario de la sesión file solo crece: no se reescribe nunca.
+	AppendJournal(file string, entry JournalEntry) error
+	ReadJournal(file string) ([]JournalEntry, error)
+	// LockSession toma el cerrojo de la sesión para que otra TUI sepa que
+	// está abierta; devuelve errSessionLocked si ya lo tiene otra.
+	LockSession(file string) (unlock func() error, err error)
+	// SessionStamp identifica el contenido guardado de la sesión, para
+	// saber si alguien la ha cambiado.
+	SessionStamp(file string) (SessionStamp, error)
+}
+
+var errSessionLocked = errors.New("session is open in another window")
+
+// SessionStamp es la fecha de modificación y el hash del archivo de sesión.
+// La fecha es solo orientativa: dos escrituras seguidas pueden dejarla igual
+// en sistemas de archivos con poca resolución, así que lo que cuenta es el hash.
+type SessionStamp struct {
+	ModTime time.Time
+	Hash    [sha256.Size]byte
+}
+
+func stampOf(modTime time.Time, data []byte) SessionStamp {
+	return SessionStamp{ModTime: modTime, Hash: sha256.Sum256(data)}
+}
+
+// changed dice si now es otro contenido. Se compara siempre el hash: con la
+// misma fecha el archivo puede haber cambiado y con otra fecha puede ser el
+// mismo.
+func (s SessionStamp) changed(now SessionStamp) bool {
+	return s.Hash != now.Hash
+}
+
+// checkName comprueba que file es un nombre de archivo sin directorios.
+func checkName(file string) error {
+	if file == "" || file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+		return fmt.Errorf("invalid file name %q", file)
+	}
+	return nil
+}
+
+// sessionVersion es la versión del formato de las sesiones que se escriben.
+// Al cambiar el formato se sube y se añade a sessionMigrations la función que
+// pasa de la versión anterior a la nueva.
+const sessionVersion = 3
+
+// sessionMigrations[v] pasa una sesión de la versión v a la v+1, trabajando
+// sobre el JSON sin decodificar.
+var sessionMigrations = map[int]func(raw map[string]json.RawMessage) error{
+	// Las sesiones sin versión son de antes de contar puntos dentro de la fila.
+	0: func(raw map[string]json.RawMessage) error {
+		if _, ok := raw["stitch"]; !ok {
+			raw["stitch"] = json.RawMessage("0")
+		}
+		return nil
+	},
+	// La versión 2 añade los contadores.
+	1: func(raw map[string]json.RawMessage) error {
+		if _, ok := raw["counters"]; !ok {
+			raw["counters"] = json.RawMessage("[]")
+		}
+		return nil
+	},
+	// La versión 3 añade los hashes del patrón; las sesiones anteriores se
+	// enlazan con el patrón la primera vez que se abren.
+	2: func(raw map[string]json.RawMessage) error {
+		return nil
+	},
+}
+
+func encodeSession(session Session) ([]byte, error) {
+	session.Version = sessionVersion
+	return json.Marshal(session)
+}
+
+// decodeSession lee una sesión de cualquier versión conocida, la pasa a la
+// actual y comprueba que tiene sentido.
+func decodeSession(file string, data []byte) (Session, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return Session{}, fmt.Errorf("session %s: empty file", file)
+	}
+	if trimmed[0] != '{' {
+		return Session{}, fmt.Errorf("session %s: not a session file: expected a JSON object", file)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Session{}, fmt.Errorf("session %s: not a session file: %v", file, err)
+	}
+
+	version := 0
+	if v, ok := raw["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil || version < 0 {
+			return Session{}, fmt.Errorf("session %s: invalid version %s", file, v)
+		}
+	}
+	if version > sessionVersion {
+		return Session{}, fmt.Errorf("session %s: version %d is newer than this program (%d)", file, version, sessionVersion)
+	}
+	for ; version < sessionVersion; version++ {
+		if err := sessionMigrations[version](raw); err != nil {
+			return Session{}, fmt.Errorf("session %s: migrating from version %d: %v", file, version, err)
+		}
+	}
+	raw["version"] = json.RawMessage(fmt.Sprint(sessionVersion))
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return Session{}, fmt.Errorf("session %s: %v", file, err)
+	}
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return Session{}, fmt.Errorf("session %s: %v", file, err)
+	}
+	if err := session.validate(); err != nil {
+		return Session{}, fmt.Errorf("session %s: %v", file, err)
+	}
+	return session, nil
+}
+
+func (s Session) validate() error {
+	switch {
+	case s.Row < 0:
+		return fmt.Errorf("negative row %d", s.Row)
+	case s.Stitch < 0:
+		return fmt.Errorf("negative stitch %d", s.Stitch)
+	case s.Pattern != "" && checkName(s.Pattern) != nil:
+		return fmt.Errorf("invalid pattern file %q", s.Pattern)
+	}
+	names := map[string]bool{}
+	for _, c := range s.Counters {
+		if err := c.validate(); err != nil {
+			return err
+		}
+		if names[c.Name] {
+			return fmt.Errorf("duplicate counter %q", c.Name)
+		}
+		names[c.Name] = true
+	}
+	return nil
+}
+
+// fileStore guarda las sesiones en SessionDir y los patrones en PatternDir.
+type fileStore struct {
+	SessionDir string
+	PatternDir string
+}
+
+// xdgDataHome es $XDG_DATA_HOME o, si no está, ~/.local/share.
+func xdgDataHome() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// newFileStore usa $XDG_DATA_HOME/goknit/sessions y
+// $XDG_DATA_HOME/goknit/patterns. Si no existen y en el directorio actual
+// están lib/ y patterns/ (la disposición antigua), se usan esos.
+func newFileStore() (*fileStore, error) {
+	data, err := xdgDataHome()
+	if err != nil {
+		return nil, err
+	}
+	store := &fileStore{
+		SessionDir: filepath.Join(data, "goknit", "sessions"),
+		PatternDir: filepath.Join(data, "goknit", "patterns"),
+	}
+	if !isDir(store.SessionDir) && isDir("lib") {
+		store.SessionDir = "lib"
+	}
+	if !isDir(store.PatternDir) && isDir("patterns") {
+		store.PatternDir = "patterns"
+	}
+	return store, nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// listFiles devuelve los archivos de dir con extensión ext, ordenados. Si dir
+// no existe no hay archivos.
+func listFiles(dir, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range entries {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), ext) {
+			files = append(files, f.Name())
+		}
+	}
+	return files, nil
+}
+
+// writeFileAtomic escribe en un temporal del mismo directorio y lo renombra,
+// así nunca queda un archivo a medias.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fileStore) ListSessions() ([]string, error) {
+	return listFiles(s.SessionDir, ".json")
+}
+
+func (s *fileStore) LoadSession(file string) (Session, error) {
+	if err := checkName(file); err != nil {
+		return Session{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.SessionDir, file))
+	if err != nil {
+		return Session{}, err
+	}
+	return decodeSession(file, data)
+}
+
+func (s *fileStore) SaveSession(file string, session Session) error {
+	if err := checkName(file); err != nil {
+		return err
+	}
+	data, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.SessionDir, file), data, 0644)
+}
+
+func (s *fileStore) ListPatterns() ([]string, error) {
+	return listFiles(s.PatternDir, ".knit")
+}
+
+func (s *fileStore) ReadPattern(file string) ([]byte, error) {
+	if err := checkName(file); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(s.PatternDir, file))
+}
+
+func (s *fileStore) WritePattern(file string, data []byte) error {
+	if err := checkName(file); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.PatternDir, file), data, 0644)
+}
+
+// El diario se guarda junto a la sesión, una entrada JSON por línea.
+func (s *fileStore) AppendJournal(file string, entry JournalEntry) error {
+	if err := checkName(file); err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.SessionDir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.SessionDir, journalFile(file)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileStore) ReadJournal(file string) ([]JournalEntry, error) {
+	if err := checkName(file); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(s.SessionDir, journalFile(file)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []JournalEntry
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e JournalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("journal %s, line %d: %v", journalFile(file), i+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (s *fileStore) LockSession(file string) (func() error, error) {
+	if err := checkName(file); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.SessionDir, 0755); err != nil {
+		return nil, err
+	}
+	return lockFile(filepath.Join(s.SessionDir, strings.TrimSuffix(file, ".json")+".lock"))
+}
+
+func (s *fileStore) SessionStamp(file string) (SessionStamp, error) {
+	if err := checkName(file); err != nil {
+		return SessionStamp{}, err
+	}
+	path := filepath.Join(s.SessionDir, file)
+	info, err := os.Stat(path)
+	if err != nil {
+		return SessionStamp{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return SessionStamp{}, err
+	}
+	return stampOf(info.ModTime(), data), nil
+}
+
+// memoryStore guarda todo en memoria. Las sesiones se guardan codificadas,
+// como en disco, para que se lean igual.
+type memoryStore struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+	patterns map[string][]byte
+	journals map[string][]JournalEntry
+	locked   map[string]bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		sessions: map[string][]byte{},
+		patterns: map[string][]byte{},
+		journals: map[string][]JournalEntry{},
+		locked:   map[string]bool{},
+	}
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *memoryStore) ListSessions() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.sessions), nil
+}
+
+func (s *memoryStore) LoadSession(file string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.sessions[file]
+	if !ok {
+		return Session{}, fmt.Errorf("session %s: %w", file, os.ErrNotExist)
+	}
+	return decodeSession(file, data)
+}
+
+func (s *memoryStore) SaveSession(file string, session Session) error {
+	if err := checkName(file); err != nil {
+		return err
+	}
+	data, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[file] = data
+	return nil
+}
+
+func (s *memoryStore) ListPatterns() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.patterns), nil
+}
+
+func (s *memoryStore) ReadPattern(file string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.patterns[file]
+	if !ok {
+		return nil, fmt.Errorf("pattern %s: %w", file, os.ErrNotExist)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *memoryStore) WritePattern(file string, data []byte) error {
+	if err := checkName(file); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.patterns[file] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memoryStore) AppendJournal(file string, entry JournalEntry) error {
+	if err := checkName(file); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journals[file] = append(s.journals[file], entry)
+	return nil
+}
+
+func (s *memoryStore) ReadJournal(file string) ([]JournalEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]JournalEntry(nil), s.journals[file]...), nil
+}
+
+func (s *memoryStore) LockSession(file string) (func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[file] {
+		return nil, errSessionLocked
+	}
+	s.locked[file] = true
+	return func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.locked, file)
+		return nil
+	}, nil
+}
+
+// En memoria no hay fechas: solo cuenta el hash.
+func (s *memoryStore) SessionStamp(file string) (SessionStamp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.sessions[file]
+	if !ok {
+		return SessionStamp{}, fmt.Errorf("session %s: %w", file, os.ErrNotExist)
+	}
+	return stampOf(time.Time{}, data), nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSessionStore prueba lo que tienen que cumplir todos los SessionStore.
+func testSessionStore(t *testing.T, store SessionStore) {
+	t.Helper()
+
+	if files, err := store.ListSessions(); err != nil || len(files) != 0 {
+		t.Fatalf("empty store: ListSessions() = %v, %v", files, err)
+	}
+	if _, err := store.LoadSession("missing.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadSession(missing) = %v, want os.ErrNotExist", err)
+	}
+
+	session := Session{
+		Id:         1,
+		Name:       "Shawl",
+		Row:        12,
+		Stitch:     3,
+		Pattern:    "lace-132.knit",
+		LastModify: time.Date(2025, 10, 23, 16, 48, 49, 0, time.UTC),
+		Counters:   []Counter{{Name: "decrease", Every: 6, Total: 7, Linked: true}},
+	}
+	for _, file := range []string{"b.json", "a.json"} {
+		if err := store.SaveSession(file, session); err != nil {
+			t.Fatalf("SaveSession(%s): %v", file, err)
+		}
+	}
+	files, err := store.ListSessions()
+	if err != nil || !slices.Equal(files, []string{"a.json", "b.json"}) {
+		t.Errorf("ListSessions() = %v, %v", files, err)
+	}
+	got, err := store.LoadSession("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := session
+	want.Version = sessionVersion
+	if got.Name != want.Name || got.Row != want.Row || got.Stitch != want.Stitch || got.Version != want.Version ||
+		!got.LastModify.Equal(want.LastModify) || !slices.Equal(got.Counters, want.Counters) {
+		t.Errorf("LoadSession() = %+v, want %+v", got, want)
+	}
+
+	for _, file := range []string{"", "../a.json", "dir/a.json", ".hidden.json"} {
+		if err := store.SaveSession(file, session); err == nil {
+			t.Errorf("SaveSession(%q) saved an invalid name", file)
+		}
+	}
+
+	if err := store.WritePattern("p.knit", []byte("section a {\nco4;\n}\n")); err != nil {
+		t.Fatal(err)
+	}
+	if patterns, err := store.ListPatterns(); err != nil || !slices.Equal(patterns, []string{"p.knit"}) {
+		t.Errorf("ListPatterns() = %v, %v", patterns, err)
+	}
+	if data, err := store.ReadPattern("p.knit"); err != nil || !strings.HasPrefix(string(data), "section a") {
+		t.Errorf("ReadPattern() = %q, %v", data, err)
+	}
+
+	entry := JournalEntry{Time: session.LastModify, Action: "advance", From: 1, To: 2, Section: "a"}
+	for range 2 {
+		if err := store.AppendJournal("a.json", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := store.ReadJournal("a.json")
+	if err != nil || len(entries) != 2 || entries[1].To != 2 || !entries[1].Time.Equal(entry.Time) {
+		t.Errorf("ReadJournal() = %+v, %v", entries, err)
+	}
+
+	unlock, err := store.LockSession("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LockSession("a.json"); !errors.Is(err, errSessionLocked) {
+		t.Errorf("second LockSession() = %v, want errSessionLocked", err)
+	}
+	if err := unlock(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err = store.LockSession("a.json")
+	if err != nil {
+		t.Fatalf("LockSession() after unlock: %v", err)
+	}
+	unlock()
+
+	before, err := store.SessionStamp("a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Row++
+	if err := store.SaveSession("a.json", session); err != nil {
+		t.Fatal(err)
+	}
+	after, err := store.SessionStamp("a.json")
+	if err != nil || !before.changed(after) {
+		t.Errorf("SessionStamp() did not change after saving: %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store := &fileStore{SessionDir: filepath.Join(dir, "sessions"), PatternDir: filepath.Join(dir, "patterns")}
+	testSessionStore(t, store)
+
+	// Al guardar no quedan temporales junto a la sesión.
+	entries, err := os.ReadDir(store.SessionDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s left in %s", e.Name(), store.SessionDir)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testSessionStore(t, newMemoryStore())
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "new", "a.json")
+	for _, data := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != data {
+			t.Errorf("read %q, %v; want %q", got, err, data)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("mode %v, want 0600", perm)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%d files in the directory, want only a.json", len(entries))
+	}
+}
+
+func TestFileStoreDecodeErrors(t *testing.T) {
+	dir := t.TempDir()
+	store := &fileStore{SessionDir: dir, PatternDir: dir}
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"", "empty file"},
+		{"  \n", "empty file"},
+		{"[1, 2]", "not a session file: expected a JSON object"},
+		{`{"row": 1`, "not a session file"},
+		{`{"version": "two"}`, "invalid version"},
+		{`{"version": -1}`, "invalid version"},
+		{`{"version": 99}`, "version 99 is newer than this program"},
+		{`{"version": 3, "row": -2}`, "negative row -2"},
+		{`{"version": 3, "pattern": "../x.knit"}`, "invalid pattern file"},
+		{`{"version": 3, "counters": [{"name": "a", "every": 2}, {"name": "a", "every": 3}]}`, `duplicate counter "a"`},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(filepath.Join(dir, "s.json"), []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.LoadSession("s.json")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("LoadSession(%q) = %v, want an error with %q", tt.data, err, tt.want)
+		}
+	}
+}
+
+func TestNewFileStore(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	t.Chdir(t.TempDir())
+
+	check := func(sessions, patterns string) {
+		t.Helper()
+		store, err := newFileStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if store.SessionDir != sessions || store.PatternDir != patterns {
+			t.Errorf("newFileStore() = %s, %s; want %s, %s", store.SessionDir, store.PatternDir, sessions, patterns)
+		}
+	}
+	xdgSessions := filepath.Join(data, "goknit", "sessions")
+	xdgPatterns := filepath.Join(data, "goknit", "patterns")
+
+	// Sin nada, los directorios XDG aunque aún no existan.
+	check(xdgSessions, xdgPatterns)
+
+	// Con la disposición antigua en el directorio actual, esa.
+	for _, dir := range []string{"lib", "patterns"} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("lib", "patterns")
+
+	// Los directorios XDG que existan mandan sobre los antiguos.
+	if err := os.MkdirAll(xdgSessions, 0755); err != nil {
+		t.Fatal(err)
+	}
+	check(xdgSessions, "patterns")
+}
+
+// Las sesiones de testdata/sessions son de cada versión anterior del formato;
+// al leerlas se pasan a la actual.
+func TestLoadSessionVersions(t *testing.T) {
+	store := &fileStore{SessionDir: filepath.Join("testdata", "sessions")}
+	modified := time.Date(2025, 10, 23, 14, 48, 49, 0, time.UTC)
+	tests := []struct {
+		file string
+		want Session
+		err  string
+	}{
+		{file: "v0.json", want: Session{
+			Version: sessionVersion, Id: 0, Name: "sesion1", Row: 12, Stitch: 0,
+			Pattern: "lace-132.knit", LastModify: modified, Counters: []Counter{},
+		}},
+		{file: "v1.json", want: Session{
+			Version: sessionVersion, Id: 1, Name: "sesion2", Row: 4, Stitch: 7,
+			Pattern: "lace-132.knit", LastModify: modified, Counters: []Counter{},
+		}},
+		{file: "v2.json", want: Session{
+			Version: sessionVersion, Id: 2, Name: "sesion3", Row: 20, Stitch: 0,
+			Pattern: "lace-171.knit", LastModify: modified,
+			Counters: []Counter{{Name: "cable", Every: 8, Total: 19, Linked: true, From: 2}},
+		}},
+		{file: "corrupt.json", err: "session corrupt.json: not a session file: unexpected end of JSON input"},
+		{file: "future.json", err: "session future.json: version 9 is newer than this program (3)"},
+		{file: "badcounter.json", err: "session badcounter.json: counter without name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := store.LoadSession(tt.file)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("LoadSession() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.LastModify.Equal(tt.want.LastModify) {
+				t.Errorf("LastModify = %v, want %v", got.LastModify, tt.want.LastModify)
+			}
+			got.LastModify = tt.want.LastModify
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadSession() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// La fecha no decide: solo cambia el stamp si cambia el contenido.
+func TestSessionStampChanged(t *testing.T) {
+	when := time.Date(2025, 10, 23, 16, 48, 49, 0, time.UTC)
+	before := stampOf(when, []byte(`{"row": 1}`))
+	if !before.changed(stampOf(when, []byte(`{"row": 2}`))) {
+		t.Error("same mtime and other content: not changed")
+	}
+	if before.changed(stampOf(when.Add(time.Second), []byte(`{"row": 1}`))) {
+		t.Error("other mtime and same content: changed")
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,119 +20,140 @@ type AppState struct {
 	session Session
 	file	string
 	maxRowNumber int
+	rows	[]*Row
+	store	SessionStore
+	saved	Session // última copia guardada o leída de la sesión
+	stamp	SessionStamp // el archivo de sesión cuando se leyó o guardó
+	unlock	func() error // suelta el cerrojo de la sesión abierta
+	readOnly	bool // la sesión está abierta en otra ventana y aquí solo se mira
 }
 
 var appState AppState
 
 type Session struct{
+	Version			int `json:"version"` // ver sessionVersion
 	Id   			int `json:"id"`
 	Name   			string `json:"name"`
 	Row 			int `json:"row"`
+	Stitch			int `json:"stitch"` // punto de la fila por el que se va, desde 0
 	Pattern			string `json:"pattern"`
 	LastModify		time.Time `json:"lastModify"`
+	Counters		[]Counter `json:"counters"`
+	PatternBinding	// hashes del patrón, ver rebind
 }
 
-func readSession(file string)  Session {
-	jsonFile, err := os.Open("lib/"+file)
+// sessionOpen dice si hay una sesión abierta.
+func sessionOpen() bool {
+	return appState.file != ""
+}
+
+// newSession crea y guarda una sesión; devuelve también el archivo en el que
+// se guarda.
+func newSession(filename string, pattern string) (Session, string, error) {
+	sessions, err := appState.store.ListSessions()
 	if err != nil {
-		fmt.Println(err)
+		return Session{}, "", err
 	}
 
-	defer jsonFile.Close()
-	
-	byteValue, _ := io.ReadAll(jsonFile)
-	var session Session
+	file := strings.ToLower(filename)
+	file = strings.ReplaceAll(file, " ", "_")
+	re := regexp.MustCompile(`[^a-z0-9_]`)
+	file = re.ReplaceAllString(file, "")
 
-	json.Unmarshal(byteValue, &session)
-	return session
+	s := Session{
+		Version: sessionVersion,
+		Id: len(sessions),
+		Name: filename,
+		Row: 0,
+		Pattern: pattern,
+		LastModify: time.Now(),
+	}
+	if err := appState.store.SaveSession(file+".json", s); err != nil {
+		return Session{}, "", err
+	}
+	return s, file+".json", nil
 }
 
-func writeSession(session Session, file string){
-	now := time.Now()
-	session.LastModify = now
+var errSessionConflict = errors.New("session was changed outside this window")
 
-	jsonFile, err := json.Marshal(session)
-	if err != nil {
-		fmt.Println(err)
+// useSession deja abierta session, guardada en file. Suelta el cerrojo de la
+// sesión anterior y toma el de esta; si otra ventana ya lo tiene la sesión se
+// abre de solo lectura y se devuelve errSessionLocked como aviso.
+func useSession(session Session, file string) error {
+	if appState.unlock != nil {
+		appState.unlock()
+		appState.unlock = nil
 	}
+	appState.session, appState.file = session, file
+	appState.readOnly = false
+	appState.saved = session
+	appState.saved.Counters = slices.Clone(session.Counters)
 
-	d1 := []byte(jsonFile)
-	err = os.WriteFile("lib/"+file, d1, 0644)
+	stamp, err := appState.store.SessionStamp(file)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
-
-}
-
-
-func listSessions() []string{
-	sessionPath := "lib/"
-	entries, err := os.ReadDir(sessionPath)
+	appState.stamp = stamp
+	unlock, err := appState.store.LockSession(file)
 	if err != nil {
-		fmt.Println(err)
-	}
-	var files[] string
-	for _, f := range entries{
-		if strings.HasSuffix(f.Name(), ".json"){
-			files = append(files, f.Name())
-		}
+		appState.readOnly = errors.Is(err, errSessionLocked)
+		return err
 	}
-	return files
+	appState.unlock = unlock
+	return nil
 }
 
-func listPattern() []string{
-	patternPath := "patterns/"
-	entries, err := os.ReadDir(patternPath)
-	if err != nil {
-		fmt.Println(err)
-	}
-	var files[] string
-	for _, f := range entries{
-		if strings.HasSuffix(f.Name(), ".knit"){
-			files = append(files, f.Name())
+// saveSession guarda la sesión abierta con la hora de guardado. Sin force,
+// si el archivo ha cambiado desde que se abrió o se guardó por última vez no
+// lo sobrescribe y devuelve errSessionConflict.
+func saveSession(force bool) error {
+	if !force {
+		stamp, err := appState.store.SessionStamp(appState.file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil && appState.stamp.changed(stamp) {
+			return errSessionConflict
 		}
 	}
-	return files
-}
-
-
-func newSession(filename string, pattern string) Session{
-	sessions := listSessions()
-
-	file := strings.ToLower(filename)
-	file = strings.ReplaceAll(file, " ", "_")
-	re := regexp.MustCompile(`[^a-z0-9_]`)
-	file = re.ReplaceAllString(file, "")
-
-	s := Session{
-		Id: len(sessions),
-		Name: filename,
-		Row: 0,
-		Pattern: pattern,
+	appState.session.LastModify = time.Now()
+	if err := appState.store.SaveSession(appState.file, appState.session); err != nil {
+		return err
 	}
-	writeSession(s, file+".json")
-	return s
+	appState.saved = appState.session
+	appState.saved.Counters = slices.Clone(appState.session.Counters)
+	stamp, err := appState.store.SessionStamp(appState.file)
+	if err != nil {
+		return err
+	}
+	appState.stamp = stamp
+	return nil
 }
 
 
+// isSafeToClose dice si la sesión abierta está guardada: se compara con la
+// última copia guardada o leída por esta ventana, no con el disco, que puede
+// haber cambiado otra. Una sesión de solo lectura no se guarda nunca.
 func isSafeToClose() bool {
-	if (Session{} != appState.session) {
-		sessionFile := readSession(appState.file)
-		return appState.session.Row == sessionFile.Row 
+	if sessionOpen() && !appState.readOnly {
+		saved := appState.saved
+		return appState.session.Row == saved.Row && appState.session.Stitch == saved.Stitch &&
+			appState.session.PatternHash == saved.PatternHash &&
+			slices.Equal(appState.session.Counters, saved.Counters)
 	}	
 	return true
 }
 
-func saveDialog(app *tview.Application, mainView *tview.Flex) *tview.Modal {
+func saveDialog(app *tview.Application, mainView *tview.Flex, save func()) *tview.Modal {
 	dialog := tview.NewModal()
 	dialog.SetText("Session not saved. Wanna save it?").
 	AddButtons([]string{"Save", "Quit"}).
 	SetDoneFunc(func(buttonIndex int, buttonLabel string) {
 		switch buttonLabel {
 		case "Save":
-			writeSession(appState.session,  appState.file)
 			mainView.RemoveItem(dialog)
 			app.SetFocus(mainView)
+			save()
 		case "Quit":
 			app.Stop()
 		}
@@ -139,29 +161,125 @@ func saveDialog(app *tview.Application, mainView *tview.Flex) *tview.Modal {
 	return dialog
 }
 
-func patternWidget(filename string) *tview.Flex {
+// conflictDialog pregunta qué hacer cuando la sesión ha cambiado fuera de esta
+// ventana: sobrescribirla con la de aquí, cargar la del disco o nada.
+func conflictDialog(app *tview.Application, mainView *tview.Flex, overwrite, reload func()) *tview.Modal {
+	dialog := tview.NewModal()
+	dialog.SetText("Session " + appState.file + " was changed outside this window (another goknit?).").
+	AddButtons([]string{"Overwrite", "Reload", "Cancel"}).
+	SetDoneFunc(func(buttonIndex int, buttonLabel string) {
+		mainView.RemoveItem(dialog)
+		app.SetFocus(mainView)
+		switch buttonLabel {
+		case "Overwrite":
+			overwrite()
+		case "Reload":
+			reload()
+		}
+	})
+	return dialog
+}
+
+// loadRows lee y compila el patrón file.
+func loadRows(file string) ([]byte, []*Row, error) {
+	data, err := appState.store.ReadPattern(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	pattern, err := compilePattern(file, bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return data, pattern.Compiler.Rows, nil
+}
+
+func patternWidget(filename string) (*tview.Flex, error) {
 	patternWidget := tview.NewFlex().
 		SetDirection(tview.FlexRow)
 
-	file, _ := os.Open("patterns/"+filename)
-	c := test_compile(file)
-	rows := c.Rows
+	// Sin patrón no quedan filas por las que moverse.
+	appState.rows, appState.maxRowNumber = nil, 0
+	_, rows, err := loadRows(filename)
+	if err != nil {
+		return patternWidget, err
+	}
+	if len(rows) == 0 {
+		return patternWidget, fmt.Errorf("%s: pattern has no rows", filename)
+	}
 
 	for i, s := range rows {
 		item := tview.NewTextView().SetLabel("Row "+strconv.Itoa(i)+": ").SetText(s.String()).SetDynamicColors(true)
 		patternWidget.AddItem(item,1,1,false)
 	}
 	appState.maxRowNumber = len(rows)
-	return patternWidget
+	appState.rows = rows
+	return patternWidget, nil
 }
 
-func app() {
-    app := tview.NewApplication()
+// stitchProgress resalta el punto por el que se va en la fila y, si está
+// dentro de una repetición, los puntos de la vuelta en la que está. Devuelve
+// también el texto de posición, "st 3/27, rep 2/4".
+func stitchProgress(row *Row, stitch int) (string, string) {
+	if len(row.Stitches) == 0 {
+		return row.String(), ""
+	}
+	stitch = min(max(stitch, 0), len(row.Stitches)-1)
+	info := fmt.Sprintf("st %d/%d", stitch+1, len(row.Stitches))
+
+	first, last := -1, -1
+	if rep, iteration, ok := row.repeatAt(stitch); ok {
+		first = rep.Start + (iteration-1)*rep.Size
+		last = first + rep.Size - 1
+		info += fmt.Sprintf(", rep %d/%d", iteration, rep.Times)
+	}
+
+	var parts []string
+	for i, st := range row.Stitches {
+		switch {
+		case i == stitch:
+			parts = append(parts, "[black:yellow]"+st.String()+"[-:-]")
+		case i >= first && i <= last:
+			parts = append(parts, "[yellow]"+st.String()+"[-]")
+		default:
+			parts = append(parts, st.String())
+		}
+	}
+	return strings.Join(parts, ", "), info
+}
+
+// blockProgress describe en qué vuelta de un bloque "repeat" y en qué fila
+// del bloque está la fila, "2/2, row 5/16"; vacío si no sale de un bloque.
+func blockProgress(row *Row) string {
+	if row.Block == nil {
+		return ""
+	}
+	b := row.Block
+	return fmt.Sprintf("%d/%d, row %d/%d", b.Iteration, b.Times, b.Index, b.Size)
+}
+
+// nextRepeatStart es la primera fila después de from que empieza una vuelta
+// de un bloque "repeat", o -1 si no queda ninguna.
+func nextRepeatStart(rows []*Row, from int) int {
+	for i := from + 1; i < len(rows); i++ {
+		if b := rows[i].Block; b != nil && b.Index == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+func app(store SessionStore) {
+	appState.store = store
+	app := tview.NewApplication()
 
 	// -------------- Session Info 
 	idForm := tview.NewTextView().SetLabel("Id: ").SetText("")
 	nameForm := tview.NewTextView().SetLabel("Name: ").SetText("")
+	sectionForm := tview.NewTextView().SetLabel("Section: ").SetText("")
+	blockForm := tview.NewTextView().SetLabel("Repeat: ").SetText("")
 	rowForm := tview.NewTextView().SetLabel("Row: ").SetText("")
+	stitchForm := tview.NewTextView().SetLabel("Stitch: ").SetText("")
+	countersForm := tview.NewTextView().SetDynamicColors(true)
 
 	// -------------- Declaring widgets
 	sessionInfoBox := tview.NewFlex()
@@ -178,6 +296,13 @@ func app() {
 	patternBox := tview.NewFlex()
 	patternBox.SetTitle("Pattern").SetBorder(true)
 
+	// Con showChart se ve el gráfico en vez de las filas escritas.
+	chart := newChartView()
+	showChart := false
+
+	// Editor abierto en el panel del patrón; nil si no hay.
+	var editor *patternEditor
+
 	left := tview.NewFlex().SetDirection(tview.FlexRow)
 	placeHolder := tview.NewFlex().SetDirection(tview.FlexRow)
 	placeHolder.SetBorder(true)
@@ -197,15 +322,96 @@ func app() {
 		log.SetText(fmt.Sprintf("%s [%s]: %s\n", prevText, time.Now().Format("03:04:00"), text))
 	}
 
+	// updateCounters pinta los contadores; los que acaban de saltar, en rojo.
+	updateCounters := func() {
+		var lines []string
+		for _, c := range appState.session.Counters {
+			if c.triggered() {
+				lines = append(lines, "[red]Counter "+c.String()+" <- now[-]")
+			} else {
+				lines = append(lines, "Counter "+c.String())
+			}
+		}
+		countersForm.SetText(strings.Join(lines, "\n"))
+	}
+
+	// alertCounters avisa en el log de los contadores que saltan.
+	alertCounters := func(fired []string) {
+		for _, name := range fired {
+			updateLog("Counter " + name + " triggered")
+		}
+		updateCounters()
+	}
+
+	// editable dice si se puede cambiar la sesión abierta y, si no, avisa.
+	editable := func() bool {
+		if appState.readOnly {
+			updateLog("Session " + appState.file + " is read-only: it is open in another window")
+			return false
+		}
+		return true
+	}
+
+	// rowChanged apunta en el diario el cambio de fila desde from.
+	rowChanged := func(from int) {
+		to := appState.session.Row
+		if to == from || from >= len(appState.rows) {
+			return
+		}
+		action := "jump"
+		if to == from+1 {
+			action = "advance"
+		} else if to == from-1 {
+			action = "retreat"
+		}
+		entry := JournalEntry{Time: time.Now(), Action: action, From: from, To: to, Section: appState.rows[from].Section}
+		if err := appState.store.AppendJournal(appState.file, entry); err != nil {
+			updateLog("Error writing journal: " + err.Error())
+		}
+	}
+
+	// rowCounted mueve los contadores enlazados al pasar de la fila from a
+	// una de al lado con las teclas de fila o de punto: al avanzar cuenta la
+	// fila acabada y al retroceder la descuenta. Los saltos no cuentan.
+	rowCounted := func(from int) {
+		switch to := appState.session.Row; to {
+		case from + 1:
+			alertCounters(stepCounters(appState.session.Counters, true, from, 1))
+		case from - 1:
+			alertCounters(stepCounters(appState.session.Counters, true, to, -1))
+		}
+	}
+
 	updatePattern := func () {
 		patternBox.Clear()
-		widget := patternWidget(appState.session.Pattern)
+		widget, err := patternWidget(appState.session.Pattern)
+		if err != nil {
+			updateLog(err.Error())
+			return
+		}
+		// La fila puede quedar fuera si el patrón ha cambiado.
+		if appState.session.Row < 0 || appState.session.Row >= appState.maxRowNumber {
+			updateLog(fmt.Sprintf("Row %d is out of the pattern (%d rows); moved to the last row", appState.session.Row, appState.maxRowNumber))
+			appState.session.Row = appState.maxRowNumber - 1
+			appState.session.Stitch = 0
+		}
 		focus := widget.GetItem(appState.session.Row).(*tview.TextView)
 		label := focus.GetLabel()
-		text := focus.GetText(false)
+		text, info := stitchProgress(appState.rows[appState.session.Row], appState.session.Stitch)
 		focus.SetLabel("[black:white]" + label)
-		focus.SetText("[black:white]" + text)
-		patternBox.AddItem(widget, 0, 1, false)
+		focus.SetText(text)
+		stitchForm.SetText(info)
+		sectionForm.SetText(appState.rows[appState.session.Row].Section)
+		blockForm.SetText(blockProgress(appState.rows[appState.session.Row]))
+		chart.setRows(appState.rows)
+		chart.setCurrent(appState.rows[appState.session.Row], appState.session.Stitch)
+		if showChart {
+			patternBox.SetTitle("Chart")
+			patternBox.AddItem(chart, 0, 1, false)
+		} else {
+			patternBox.SetTitle("Pattern")
+			patternBox.AddItem(widget, 0, 1, false)
+		}
 	}
 
 	updatePlaceHolder(sessionInfoBox, "Knitting instruction")
@@ -214,10 +420,19 @@ func app() {
 		sessionInfoBox.Clear()
 		sessionInfoBox.AddItem(idForm, 1, 0, false).
 			AddItem(nameForm, 1, 0, false).
-			AddItem(rowForm, 1, 0, false)
+			AddItem(sectionForm, 1, 0, false).
+			AddItem(blockForm, 1, 0, false).
+			AddItem(rowForm, 1, 0, false).
+			AddItem(countersForm, len(appState.session.Counters), 0, false).
+			AddItem(stitchForm, 1, 0, false)
 		idForm.SetText(strconv.Itoa(appState.session.Id))
-		nameForm.SetText(appState.session.Name)
-		rowForm.SetText(strconv.Itoa(appState.session.Row))
+		if appState.readOnly {
+			nameForm.SetText(appState.session.Name + " (read-only)")
+		} else {
+			nameForm.SetText(appState.session.Name)
+		}
+		rowForm.SetText(fmt.Sprintf("%d/%d", appState.session.Row, appState.maxRowNumber-1))
+		updateCounters()
 		updatePlaceHolder(sessionInfoBox, "Knitting instructions")
 		app.SetFocus(actionList)
 		updatePattern()
@@ -225,13 +440,71 @@ func app() {
 
 
 
+	// openSession abre session; si está abierta en otra ventana se abre de
+	// solo lectura, para no escribir las dos en la sesión ni en su diario.
+	// Al abrirla se comprueba además si su patrón ha cambiado.
+	openSession := func(session Session, file string) {
+		err := useSession(session, file)
+		if errors.Is(err, errSessionLocked) {
+			updateLog("Session " + file + " is open in another window; opened read-only")
+		} else if err != nil {
+			updateLog(err.Error())
+		}
+		if data, rows, err := loadRows(appState.session.Pattern); err != nil {
+			updateLog(err.Error())
+		} else {
+			if warning := rebind(&appState.session, data, rows); warning != "" {
+				updateLog(warning)
+			}
+			// Enlazar por primera vez una sesión no es un cambio sin guardar.
+			if appState.saved.PatternHash == "" {
+				appState.saved.PatternBinding = appState.session.PatternBinding
+			}
+		}
+		updateInfo()
+	}
+
+	// save guarda la sesión y, si ha cambiado fuera, pregunta qué hacer.
+	var save func(force bool)
+	save = func(force bool) {
+		if !editable() {
+			return
+		}
+		err := saveSession(force)
+		if errors.Is(err, errSessionConflict) {
+			updateLog(err.Error())
+			reload := func() {
+				session, err := appState.store.LoadSession(appState.file)
+				if err != nil {
+					updateLog(err.Error())
+					return
+				}
+				openSession(session, appState.file)
+				updateLog("Reloaded " + appState.file)
+			}
+			m := conflictDialog(app, flex, func() { save(true) }, reload)
+			flex.AddItem(m, 0, 1, true)
+			app.SetFocus(m)
+			return
+		}
+		if err != nil {
+			updateLog("Error saving " + appState.file + ": " + err.Error())
+			return
+		}
+		updateLog("Saved file: " + appState.file)
+	}
+
 	actionList.AddItem("pattern","", 'p', func(){
 		if !isSafeToClose(){
 			updateLog("Not saved")
 			return
 		}
 
-		patterns := listPattern()
+		patterns, err := appState.store.ListPatterns()
+		if err != nil {
+			updateLog(err.Error())
+			return
+		}
 		patternsList := tview.NewDropDown().SetLabel("Select a pattern")
 		form := tview.NewForm()
 		var file string
@@ -250,9 +523,12 @@ func app() {
 			AddInputField("Session name", "", 0, nil, nil).
 			AddButton("Save", func(){
 				name := form.GetFormItem(2).(*tview.InputField).GetText()
-				appState.session = newSession(name, file)
-				appState.file = name+".json"
-				updateInfo()
+				session, sessionFile, err := newSession(name, file)
+				if err != nil {
+					updateLog(err.Error())
+					return
+				}
+				openSession(session, sessionFile)
 			})
 		updatePlaceHolder(form, "Open session")
 		app.SetFocus(form)
@@ -262,15 +538,22 @@ func app() {
 				updateLog("Not saved")
 				return
 			}
-			sessions := listSessions()
+			sessions, err := appState.store.ListSessions()
+			if err != nil {
+				updateLog(err.Error())
+				return
+			}
 			listMenu := tview.NewFlex().SetDirection(tview.FlexRow)
 			sessionEntries := tview.NewFlex().SetDirection(tview.FlexRow)
 			sessionList := tview.NewDropDown().SetLabel("Select an option (hit Enter): ")
 
 			sessionList.SetOptions(sessions, func(text string, index int) {
-				appState.session = readSession(text)
-				appState.file = text
-				updateInfo()
+				session, err := appState.store.LoadSession(text)
+				if err != nil {
+					updateLog(err.Error())
+					return
+				}
+				openSession(session, text)
 			})
 
 			t := tview.NewTextView().SetText(strings.Join(sessions, "\n"))
@@ -289,26 +572,202 @@ func app() {
 			form.AddInputField("Session name", "", 20, nil, nil).
 				AddButton("Save", func(){
 					name := form.GetFormItem(0).(*tview.InputField).GetText()
-					appState.session = newSession(name, appState.session.Pattern)
-					updateInfo()
+					session, sessionFile, err := newSession(name, appState.session.Pattern)
+					if err != nil {
+						updateLog(err.Error())
+						return
+					}
+					openSession(session, sessionFile)
 				}).
 				AddButton("Quit", func() {
 					app.SetFocus(actionList)
 				})
 			updatePlaceHolder(form, "New session")
 		}).
+		AddItem("edit", "", 'e', func() {
+			openEditor := func(file string) {
+				closeEditor := func() {
+					editor = nil
+					patternBox.Clear()
+					patternBox.SetTitle("Pattern")
+					if sessionOpen() {
+						updatePattern()
+					}
+					app.SetFocus(actionList)
+				}
+				onSave := func(pattern *CompiledPattern) {
+					// La sesión sigue en la misma fila del patrón editado.
+					if file != appState.session.Pattern {
+						return
+					}
+					data, err := appState.store.ReadPattern(file)
+					if err != nil {
+						updateLog(err.Error())
+						return
+					}
+					if warning := rebind(&appState.session, data, pattern.Compiler.Rows); warning != "" {
+						updateLog(warning)
+					}
+				}
+				e, err := newPatternEditor(appState.store, file, updateLog, onSave, closeEditor)
+				if err != nil {
+					updateLog(err.Error())
+					return
+				}
+				editor = e
+				patternBox.Clear()
+				patternBox.SetTitle("Editor")
+				patternBox.AddItem(editor.layout, 0, 1, true)
+				app.SetFocus(editor.area)
+			}
+			if appState.session.Pattern != "" {
+				openEditor(appState.session.Pattern)
+				return
+			}
+			form := tview.NewForm()
+			patterns, err := appState.store.ListPatterns()
+			if err != nil {
+				updateLog(err.Error())
+				return
+			}
+			form.AddDropDown("Pattern", patterns, -1, func(text string, index int) {
+				if text != "" {
+					openEditor(text)
+				}
+			})
+			form.AddInputField("New pattern", "", 20, nil, nil).
+				AddButton("Edit", func() {
+					name := form.GetFormItem(1).(*tview.InputField).GetText()
+					if name == "" {
+						return
+					}
+					if !strings.HasSuffix(name, ".knit") {
+						name += ".knit"
+					}
+					openEditor(name)
+				})
+			updatePlaceHolder(form, "Edit pattern")
+		}).
+		AddItem("counters", "", 'k', func() {
+			if !sessionOpen() {
+				updateLog("No session open")
+				return
+			}
+			if !editable() {
+				return
+			}
+			var names []string
+			for _, c := range appState.session.Counters {
+				names = append(names, c.Name)
+			}
+			form := tview.NewForm()
+			form.AddInputField("Name", "", 20, nil, nil).
+				AddInputField("Every (rows)", "", 5, tview.InputFieldInteger, nil).
+				AddCheckbox("Linked to rows", true, nil).
+				AddInputField("From row", strconv.Itoa(appState.session.Row), 5, tview.InputFieldInteger, nil).
+				AddInputField("To row (0: end)", "0", 5, tview.InputFieldInteger, nil).
+				AddDropDown("Counter", names, -1, nil)
+			field := func(i int) int {
+				n, _ := strconv.Atoi(form.GetFormItem(i).(*tview.InputField).GetText())
+				return n
+			}
+			form.AddButton("Add", func() {
+				c := Counter{
+					Name:   strings.TrimSpace(form.GetFormItem(0).(*tview.InputField).GetText()),
+					Every:  field(1),
+					Linked: form.GetFormItem(2).(*tview.Checkbox).IsChecked(),
+					From:   field(3),
+					To:     field(4),
+				}
+				if err := c.validate(); err != nil {
+					updateLog(err.Error())
+					return
+				}
+				if slices.ContainsFunc(appState.session.Counters, func(other Counter) bool { return other.Name == c.Name }) {
+					updateLog("Counter " + c.Name + " already exists")
+					return
+				}
+				appState.session.Counters = append(appState.session.Counters, c)
+				updateLog("Added counter " + c.Name)
+				updateInfo()
+			}).
+				AddButton("Reset", func() {
+					if i, _ := form.GetFormItem(5).(*tview.DropDown).GetCurrentOption(); i >= 0 {
+						appState.session.Counters[i].Total = 0
+						updateInfo()
+					}
+				}).
+				AddButton("Remove", func() {
+					if i, _ := form.GetFormItem(5).(*tview.DropDown).GetCurrentOption(); i >= 0 {
+						appState.session.Counters = slices.Delete(appState.session.Counters, i, i+1)
+						updateInfo()
+					}
+				}).
+				AddButton("Back", func() {
+					updateInfo()
+				})
+			updatePlaceHolder(form, "Counters")
+		}).
+		AddItem("journal", "", 'j', func() {
+			if !sessionOpen() {
+				updateLog("No session open")
+				return
+			}
+			entries, err := appState.store.ReadJournal(appState.file)
+			if err != nil {
+				updateLog(err.Error())
+				return
+			}
+			stats := journalStats(entries, appState.maxRowNumber-1-appState.session.Row)
+			var b strings.Builder
+			b.WriteString(stats.String())
+			b.WriteString("\nLast rows:\n")
+			for _, e := range entries[max(len(entries)-20, 0):] {
+				fmt.Fprintf(&b, "%s %-7s %d -> %d (%s)\n", e.Time.Format("2006-01-02 15:04"), e.Action, e.From, e.To, e.Section)
+			}
+			view := tview.NewTextView().SetText(b.String())
+			form := tview.NewForm()
+			form.AddInputField("CSV file", strings.TrimSuffix(appState.file, ".json")+"-journal.csv", 30, nil, nil).
+				AddButton("Export", func() {
+					path := form.GetFormItem(0).(*tview.InputField).GetText()
+					f, err := os.Create(path)
+					if err != nil {
+						updateLog(err.Error())
+						return
+					}
+					err = writeJournalCSV(f, entries)
+					if cerr := f.Close(); err == nil {
+						err = cerr
+					}
+					if err != nil {
+						updateLog("Error exporting journal: " + err.Error())
+						return
+					}
+					updateLog("Journal exported to " + path)
+				}).
+				AddButton("Back", func() {
+					updateInfo()
+				})
+			journal := tview.NewFlex().SetDirection(tview.FlexRow).
+				AddItem(view, 0, 1, false).
+				AddItem(form, 5, 0, true)
+			updatePlaceHolder(journal, "Journal")
+			app.SetFocus(form)
+		}).
 		AddItem("save", "", 's', func() {
+			if sessionOpen() && !editable() {
+				return
+			}
 			if isSafeToClose(){
 				updateLog("Already saved")
 				return
 			}
-			writeSession(appState.session,  appState.file)
-			updateLog("Saved file: "+appState.file)
+			save(false)
 		}).
 		AddItem("close", "", 'q', func() {
 			if !isSafeToClose(){
 				updateLog("Not saved")
-				m := saveDialog(app, flex) 
+				m := saveDialog(app, flex, func() { save(false) })
 				flex.AddItem(m, 0,1, true)
 				app.SetFocus(m)
 				return
@@ -328,26 +787,99 @@ func app() {
 
 	
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		// Mientras se escribe en un campo las teclas son texto.
+		if _, typing := app.GetFocus().(*tview.InputField); typing || editor != nil {
+			return event
+		}
+		// Solo las teclas de moverse apuntan en el diario; abrir o recargar una
+		// sesión cambia la fila sin que se haya tejido nada.
+		from := appState.session.Row
 		if event.Rune() == '+' {
-			if (Session{}) == appState.session {return nil}
+			if !sessionOpen() || len(appState.rows) == 0 || !editable() {return nil}
 			if appState.session.Row < appState.maxRowNumber-1 {
 				appState.session.Row++
 			}else{
 				appState.session.Row = 0
 			}
+			appState.session.Stitch = 0
 			updateInfo()
+			rowChanged(from)
+			rowCounted(from)
 		} else if event.Rune() == '-' {
+			if !sessionOpen() || len(appState.rows) == 0 || !editable() {return nil}
 			if appState.session.Row > 0 {
 				appState.session.Row--
 			}else{
 				appState.session.Row = appState.maxRowNumber-1
 			}
+			appState.session.Stitch = 0
+			updateInfo()
+			rowChanged(from)
+			rowCounted(from)
+		} else if event.Rune() == '.' {
+			// Punto siguiente; al acabar la fila se pasa a la siguiente.
+			if !sessionOpen() || len(appState.rows) == 0 || !editable() {return nil}
+			if appState.session.Stitch < len(appState.rows[appState.session.Row].Stitches)-1 {
+				appState.session.Stitch++
+			} else {
+				appState.session.Stitch = 0
+				appState.session.Row = (appState.session.Row + 1) % appState.maxRowNumber
+			}
+			updateInfo()
+			rowChanged(from)
+			rowCounted(from)
+		} else if event.Rune() == ',' {
+			if !sessionOpen() || len(appState.rows) == 0 || !editable() {return nil}
+			if appState.session.Stitch > 0 {
+				appState.session.Stitch--
+			} else {
+				appState.session.Row = (appState.session.Row - 1 + appState.maxRowNumber) % appState.maxRowNumber
+				appState.session.Stitch = max(len(appState.rows[appState.session.Row].Stitches)-1, 0)
+			}
+			updateInfo()
+			rowChanged(from)
+			rowCounted(from)
+		} else if event.Rune() == 'r' {
+			// Salta al principio de la siguiente vuelta de un bloque "repeat".
+			if !sessionOpen() || !editable() {return nil}
+			next := nextRepeatStart(appState.rows, appState.session.Row)
+			if next < 0 {
+				updateLog("No more repeats")
+				return nil
+			}
+			appState.session.Row = next
+			appState.session.Stitch = 0
 			updateInfo()
+			rowChanged(from)
+		} else if event.Rune() == 'c' || event.Rune() == 'C' {
+			// Avanza (c) o retrocede (C) los contadores manuales.
+			if !sessionOpen() || !editable() {return nil}
+			delta := 1
+			if event.Rune() == 'C' {
+				delta = -1
+			}
+			alertCounters(stepCounters(appState.session.Counters, false, appState.session.Row, delta))
+			return nil
+		} else if event.Rune() == 'v' {
+			// Cambia entre el patrón escrito y el gráfico.
+			if !sessionOpen() {return nil}
+			showChart = !showChart
+			updatePattern()
+		} else if event.Rune() == 'u' {
+			chart.ascii = !chart.ascii
+		} else if showChart && (event.Key() == tcell.KeyPgUp || event.Key() == tcell.KeyPgDn) {
+			chart.InputHandler()(event, nil)
+			return nil
 		}
 		return event
 	})
 
 		
+	defer func() {
+		if appState.unlock != nil {
+			appState.unlock()
+		}
+	}()
 	if err := app.SetRoot(flex, true).EnableMouse(true).Run(); err != nil {
 		panic(err)
 	}